@@ -5,7 +5,7 @@ module libs::offchain_merkle_tree {
     use sui::bcs::to_bytes;
     use std::vector;
     use sui::address::{to_u256, from_bytes};
-    use libs::queue::{enqueue, create_queue, lenth, dequeue, peek, Queue};
+    use libs::queue::{enqueue, create_queue, lenth, dequeue, batch_dequeue, peek, Queue};
     use sui::tx_context::TxContext;
     use libs::tree_utils::{u256_to_field_elem_limbs, encode_path_and_hash};
 
@@ -15,6 +15,7 @@ module libs::offchain_merkle_tree {
     const EMPTY_TREE_ROOT: u256 = 9533201250583817767896570092866591469094150406835227552485691564931228351592;
 
     const EBatchLenNotEqualToBatchSize: u64 = 1;
+    const ENoBatches: u64 = 2;
 
     struct OffchainMerkleTree has store {
         count: u64,
@@ -43,6 +44,36 @@ module libs::offchain_merkle_tree {
         return pis
     }
 
+    //TODO: verify proof, then make it public
+    // applies the first nb_batches batches of the queue at once, into adjacent subtrees.
+    // Friends only: without a proof check anyone could overwrite the root of several batches.
+    public(friend) fun apply_subtree_updates(
+        self: &mut OffchainMerkleTree,
+        new_root: u256,
+        nb_batches: u64,
+        // proof: vector<u256>
+    ): vector<u256> {
+        assert!(nb_batches > 0, ENoBatches);
+        let accumulator_hashes = batch_dequeue(&mut self.accumulator_queue, nb_batches);
+        let pis = vector::empty<u256>();
+        vector::push_back(&mut pis, self.root);
+        vector::push_back(&mut pis, new_root);
+        let i = 0;
+        loop {
+            if (i < nb_batches) {
+                let (hi, lo) = u256_to_field_elem_limbs(*vector::borrow(&accumulator_hashes, i));
+                vector::push_back(&mut pis, encode_path_and_hash(self.count + i * BATCH_SIZE, hi));
+                vector::push_back(&mut pis, lo);
+                i = i + 1;
+            } else {
+                break
+            }
+        };
+        self.root = new_root;
+        self.count = self.count + nb_batches * BATCH_SIZE;
+        return pis
+    }
+
     fun insert_update(self: &mut OffchainMerkleTree, update: vector<u8>) {
         vector::push_back(&mut self.batch, update);
         self.batch_len = self.batch_len + 1;
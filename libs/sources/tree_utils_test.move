@@ -12,6 +12,7 @@ module libs::tree_utils_test {
     use sui::object;
     use libs::types::create_encoded_note;
     use libs::queue::peek;
+    use libs::tree_utils::{encode_path_and_hash, u256_to_field_elem_limbs};
 
     struct TestStruct has key {
         id: UID,
@@ -28,6 +29,27 @@ module libs::tree_utils_test {
         let res = sha2_256(to_bytes(&arr));
         print(&res);
         print(&to_u256(from_bytes(res)));
+        // same value as TestEncodeBatchMatchesMove in zk/circuits/subtreeUpdate/bcs
+        assert!(to_u256(from_bytes(res)) == 53542807168996682538671137912585950357137197673136836691452017684570176924519, 0);
+    }
+
+    // same vectors as TestMoveVectors in zk/circuits/subtreeUpdate/treeutils
+    #[test]
+    fun encode_path_and_hash_test() {
+        assert!(encode_path_and_hash(0, 0) == 0, 0);
+        assert!(encode_path_and_hash(16, 7) == 1879048193, 0);
+        assert!(encode_path_and_hash(4096, 5) == 1342177536, 0);
+        assert!(encode_path_and_hash(4294967280, 7) == 2147483647, 0);
+    }
+
+    #[test]
+    fun u256_to_field_elem_limbs_test() {
+        let (hi, lo) = u256_to_field_elem_limbs(35391017205645498333932229288428234435008798448262589111347252333824232751540);
+        assert!(hi == 2, 0);
+        assert!(lo == 6442994896316449478039483036256257471691302281852448101482856331845950341556, 0);
+        let (hi, lo) = u256_to_field_elem_limbs(115792089237316195423570985008687907853269984665640564039457584007913129639935);
+        assert!(hi == 7, 0);
+        assert!(lo == 14474011154664524427946373126085988481658748083205070504932198000989141204991, 0);
     }
 
     #[test]
@@ -67,6 +89,8 @@ module libs::tree_utils_test {
             };
             let acc_hash = peek(get_queue(&mut test.tree));
             print(&acc_hash);
+            // same value as circuit.AccumulatorHash in zk/circuits/subtreeUpdate
+            assert!(acc_hash == 35391017205645498333932229288428234435008798448262589111347252333824232751540, 0);
             print(&test.tree);
             test_scenario::return_shared(test_val);
         };
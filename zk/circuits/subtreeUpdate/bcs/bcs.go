@@ -0,0 +1,47 @@
+// Package bcs implements the subset of BCS, the serialization used by sui::bcs::to_bytes,
+// needed to reproduce the bytes hashed on-chain.
+package bcs
+
+import (
+	"encoding/binary"
+	"math/big"
+)
+
+// AppendULEB128 appends n as an unsigned LEB128, the encoding of sequence lengths.
+func AppendULEB128(b []byte, n uint64) []byte {
+	for n >= 0x80 {
+		b = append(b, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(b, byte(n))
+}
+
+// AppendBytes appends v as a vector<u8>.
+func AppendBytes(b []byte, v []byte) []byte {
+	b = AppendULEB128(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func AppendU64(b []byte, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+// AppendU256 appends v as a little-endian u256. v must fit in 256 bits.
+func AppendU256(b []byte, v *big.Int) []byte {
+	var le [32]byte
+	v.FillBytes(le[:])
+	for i, j := 0, len(le)-1; i < j; i, j = i+1, j-1 {
+		le[i], le[j] = le[j], le[i]
+	}
+	return append(b, le[:]...)
+}
+
+// EncodeBatch encodes note hashes as the vector<vector<u8>> batch of
+// libs::offchain_merkle_tree.
+func EncodeBatch(hashes [][32]byte) []byte {
+	b := AppendULEB128(nil, uint64(len(hashes)))
+	for i := range hashes {
+		b = AppendBytes(b, hashes[i][:])
+	}
+	return b
+}
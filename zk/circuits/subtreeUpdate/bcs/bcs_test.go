@@ -0,0 +1,70 @@
+package bcs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func TestAppendULEB128(t *testing.T) {
+	for _, c := range []struct {
+		n    uint64
+		want string
+	}{
+		{0, "00"},
+		{0x10, "10"},
+		{0x7f, "7f"},
+		{0x80, "8001"},
+		{529, "9104"},
+		{1 << 32, "8080808010"},
+	} {
+		if got := hex.EncodeToString(AppendULEB128(nil, c.n)); got != c.want {
+			t.Errorf("%d: got %s, want %s", c.n, got, c.want)
+		}
+	}
+}
+
+func TestAppendU256(t *testing.T) {
+	v, _ := new(big.Int).SetString("0102030405060708091011121314151617181920212223242526272829303132", 16)
+	want, _ := hex.DecodeString("3231302928272625242322212019181716151413121110090807060504030201")
+	if got := AppendU256(nil, v); !bytes.Equal(got, want) {
+		t.Fatalf("got %x, want %x", got, want)
+	}
+	if got := AppendU64(nil, 1); !bytes.Equal(got, []byte{1, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Fatalf("got %x", got)
+	}
+}
+
+// TestEncodeBatchMatchesMove reproduces sha256_tree_test in libs/sources/tree_utils_test.move,
+// which hashes a vector<vector<u8>> of two BCS encoded x"1a".
+func TestEncodeBatchMatchesMove(t *testing.T) {
+	x := AppendBytes(nil, []byte{0x1a})
+	arr := AppendULEB128(nil, 2)
+	arr = AppendBytes(arr, x)
+	arr = AppendBytes(arr, x)
+	if got := hex.EncodeToString(arr); got != "0202011a02011a" {
+		t.Fatalf("got %s", got)
+	}
+	h := sha256.Sum256(arr)
+	if got := new(big.Int).SetBytes(h[:]).String(); got != "53542807168996682538671137912585950357137197673136836691452017684570176924519" {
+		t.Fatalf("got %s", got)
+	}
+
+	var batch [][32]byte
+	for i := 0; i < 16; i++ {
+		var n [32]byte
+		n[0] = byte(i)
+		batch = append(batch, n)
+	}
+	enc := EncodeBatch(batch)
+	if len(enc) != 1+16*33 || enc[0] != 0x10 {
+		t.Fatalf("batch encoded to %d bytes starting with %x", len(enc), enc[0])
+	}
+	for i := range batch {
+		if enc[1+33*i] != 0x20 || !bytes.Equal(enc[2+33*i:1+33*(i+1)], batch[i][:]) {
+			t.Fatalf("note hash %d: got %x", i, enc[1+33*i:1+33*(i+1)])
+		}
+	}
+}
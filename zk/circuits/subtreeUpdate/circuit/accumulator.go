@@ -0,0 +1,66 @@
+package circuit
+
+import (
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"math/big"
+	"subtreeUpdate/poseidon"
+	"subtreeUpdate/tree"
+)
+
+// Accumulator is the hash committing to the notes of a batch, the accumulator hash.
+type Accumulator int
+
+const (
+	// SHA256 is compute_accumulator_hash, the sha256 of the BCS encoding of the note hashes,
+	// see AccumulatorHash.
+	SHA256 Accumulator = iota
+	// Poseidon is a Poseidon hash chain over the leaves of the batch, see
+	// PoseidonAccumulatorHash. It is much cheaper to prove, but the Move contract does not
+	// compute it yet.
+	Poseidon
+)
+
+func (a Accumulator) String() string {
+	if a == Poseidon {
+		return "poseidon"
+	}
+	return "sha256"
+}
+
+// poseidonChunk is the number of leaves hashed by one Poseidon permutation, the most the
+// poseidon package has constants for.
+const poseidonChunk = 16
+
+// PoseidonAccumulatorHash is the accumulator hash of a batch with the Poseidon accumulator.
+// The leaves of the note hashes, see tree.NoteLeaf, are hashed 16 at a time, each hash
+// taking the previous one, or 0, as initial state. The hash of a batch of at most 16 notes
+// is the Poseidon of its leaves, as computed by sui::poseidon::poseidon_bn254.
+func PoseidonAccumulatorHash(noteHashes [][32]byte) *big.Int {
+	leaves := make([]fr.Element, len(noteHashes))
+	for i, n := range noteHashes {
+		leaves[i].SetBigInt(tree.NoteLeaf(n))
+	}
+	var acc fr.Element
+	for i := 0; i < len(leaves); i += poseidonChunk {
+		end := i + poseidonChunk
+		if end > len(leaves) {
+			end = len(leaves)
+		}
+		acc = poseidon.PoseidonExNative(leaves[i:end], acc, 1)[0]
+	}
+	return acc.BigInt(new(big.Int))
+}
+
+// poseidonAccumulator is the gadget of PoseidonAccumulatorHash.
+func poseidonAccumulator(api frontend.API, leaves []frontend.Variable) frontend.Variable {
+	acc := frontend.Variable(0)
+	for i := 0; i < len(leaves); i += poseidonChunk {
+		end := i + poseidonChunk
+		if end > len(leaves) {
+			end = len(leaves)
+		}
+		acc = poseidon.PoseidonEx(api, leaves[i:end], acc, 1)[0]
+	}
+	return acc
+}
@@ -0,0 +1,132 @@
+package circuit
+
+import (
+	"errors"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"math/big"
+	"subtreeUpdate/poseidon"
+	"subtreeUpdate/tree"
+	"testing"
+)
+
+type poseidonAccumulatorCircuit struct {
+	Leaves []frontend.Variable
+	Hash   frontend.Variable `gnark:",public"`
+}
+
+func (c *poseidonAccumulatorCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(poseidonAccumulator(api, c.Leaves), c.Hash)
+	return nil
+}
+
+func TestPoseidonAccumulatorHash(t *testing.T) {
+	// a batch of 16 is one Poseidon of its leaves
+	batch := randomNoteHashes(0)
+	leaves := make([]*big.Int, len(batch))
+	for i, n := range batch {
+		leaves[i] = tree.NoteLeaf(n)
+	}
+	if got, want := PoseidonAccumulatorHash(batch), poseidon.PoseidonNative(leaves...); got.Cmp(want) != 0 {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	// larger batches are chained through the initial state
+	batch = noteHashes(1, 40)
+	var acc fr.Element
+	for _, chunk := range [][][32]byte{batch[:16], batch[16:32], batch[32:]} {
+		in := make([]fr.Element, len(chunk))
+		for i, n := range chunk {
+			in[i].SetBigInt(tree.NoteLeaf(n))
+		}
+		acc = poseidon.PoseidonExNative(in, acc, 1)[0]
+	}
+	if got, want := PoseidonAccumulatorHash(batch), acc.BigInt(new(big.Int)); got.Cmp(want) != 0 {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	for _, n := range []int{1, 4, 16, 17, 40, 64} {
+		batch := noteHashes(2, n)
+		assignment := &poseidonAccumulatorCircuit{Leaves: make([]frontend.Variable, n), Hash: PoseidonAccumulatorHash(batch)}
+		for i, h := range batch {
+			assignment.Leaves[i] = tree.NoteLeaf(h)
+		}
+		circuit := &poseidonAccumulatorCircuit{Leaves: make([]frontend.Variable, n)}
+		if err := test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()); err != nil {
+			t.Fatalf("%d leaves: %v", n, err)
+		}
+		assignment.Leaves[0], assignment.Leaves[n-1] = assignment.Leaves[n-1], assignment.Leaves[0]
+		if err := test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()); n > 1 && err == nil {
+			t.Fatalf("%d leaves: swapped leaves accepted", n)
+		}
+	}
+}
+
+func TestPoseidonAccumulatorConfig(t *testing.T) {
+	c := Config{Depth: tree.Depth, BatchSize: tree.BatchSize, Accumulator: Poseidon}
+	circuit, err := NewCircuit(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := buildTree(t, 1, 100)
+	w, err := c.BuildWitness(tr, randomNoteHashes(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.IsSolved(circuit, w.Assignment, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+	// same tree as the sha256 update, other public inputs
+	sha, err := BuildWitness(tr, randomNoteHashes(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.NewRoot.Cmp(sha.NewRoot) != 0 || w.AccumulatorHash.Cmp(PoseidonAccumulatorHash(randomNoteHashes(0))) != 0 {
+		t.Fatal("poseidon witness does not insert the batch")
+	}
+	if w.Assignment.EncodedPathAndHash.(*big.Int).Uint64() != 1 || w.Assignment.AccumulatorHash.(*big.Int).Cmp(w.AccumulatorHash) != 0 {
+		t.Fatalf("encodedPathAndHash %s, accumulatorHash %s", w.Assignment.EncodedPathAndHash, w.Assignment.AccumulatorHash)
+	}
+
+	// leaves bound to the accumulator hash
+	modified := clone(w.Assignment)
+	modified.Leaves[3] = big.NewInt(42)
+	if err := test.IsSolved(circuit, modified, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("leaf differing from the preimage was accepted")
+	}
+	modified.Preimage[3] = big.NewInt(42)
+	if err := test.IsSolved(circuit, modified, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("leaf not committed to by the accumulator hash was accepted")
+	}
+
+	// partial batches pad the leaves
+	c.Partial = true
+	partial, err := NewCircuit(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := c.BuildWitness(tr, randomNoteHashes(0)[:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.IsSolved(partial, pw.Assignment, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+	hidden := clone(pw.Assignment)
+	hidden.EncodedPathAndHash = new(big.Int).Sub(pw.Assignment.EncodedPathAndHash.(*big.Int), new(big.Int).Lsh(big.NewInt(1), uint(c.hashBits())))
+	if err := test.IsSolved(partial, hidden, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("a real note was hidden in the padding")
+	}
+
+	if _, err := c.BuildWitness(tree.New(), nil); !errors.Is(err, tree.ErrBatchSize) {
+		t.Fatalf("got %v, want %v", err, tree.ErrBatchSize)
+	}
+	if _, err := (Config{Depth: 20, BatchSize: tree.BatchSize, Accumulator: Poseidon}).BuildWitness(tr, randomNoteHashes(0)); !errors.Is(err, ErrConfig) {
+		t.Fatalf("got %v, want %v", err, ErrConfig)
+	}
+	if err := (Config{Depth: tree.Depth, BatchSize: tree.BatchSize, Accumulator: 2}).Validate(); !errors.Is(err, ErrConfig) {
+		t.Fatalf("got %v, want %v", err, ErrConfig)
+	}
+}
@@ -0,0 +1,212 @@
+// Package circuit defines the subtree update circuit and builds its witnesses.
+package circuit
+
+import (
+	"fmt"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/hash"
+	"github.com/consensys/gnark/std/math/bits"
+	"subtreeUpdate/bcs"
+	"subtreeUpdate/merkle"
+	"subtreeUpdate/poseidon"
+	sha2_256 "subtreeUpdate/sha256"
+	"subtreeUpdate/tree"
+	"subtreeUpdate/treeutils"
+)
+
+// PreimageSize is the size of the note hashes of a batch of DefaultConfig. The accumulator
+// hash is the sha256 of their BCS encoding, see accumulatorPreimage.
+const PreimageSize = 32 * tree.BatchSize
+
+type SubtreeUpdateCircuit struct {
+	config Config
+	// gnark orders the public witness by declaration, the public fields follow
+	// calculate_public_inputs, see PublicInputs
+	OldRoot            frontend.Variable `gnark:"oldRoot,public"`
+	NewRoot            frontend.Variable `gnark:"newRoot,public"`
+	EncodedPathAndHash frontend.Variable `gnark:"encodedPathAndHash,public"`
+	AccumulatorHash    frontend.Variable `gnark:"accumulatorHash,public"`
+	// Siblings is the authentication path of the subtree, shared by OldRoot and NewRoot.
+	Siblings [][]frontend.Variable `gnark:"siblings,secret"`
+	Preimage []frontend.Variable   `gnark:"preImage"`
+	Leaves   []frontend.Variable   `gnark:"leaves,secret"`
+}
+
+// NewSubtreeUpdateCircuit returns a circuit of DefaultConfig ready to be compiled or
+// assigned.
+func NewSubtreeUpdateCircuit() *SubtreeUpdateCircuit {
+	c, err := NewCircuit(DefaultConfig)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// NewCircuit returns a circuit of configuration c ready to be compiled or assigned.
+func NewCircuit(c Config) (*SubtreeUpdateCircuit, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &SubtreeUpdateCircuit{
+		config:   c,
+		Siblings: c.TreeParams().NewSubtreeProof().Siblings,
+		Preimage: make([]frontend.Variable, c.PreimageSize()),
+		Leaves:   make([]frontend.Variable, c.BatchSize),
+	}, nil
+}
+
+// Config returns the configuration of the circuit.
+func (circuit *SubtreeUpdateCircuit) Config() Config {
+	return circuit.config
+}
+
+// assertLeavesMatchPreimage constrains every leaf to be the low treeutils.FieldElemLoBits
+// bits of its 32 big-endian bytes in preimage, like tree.NoteLeaf. The first byte is decomposed to
+// drop its top bits and the others are range checked when they are written to sha256, so
+// the sum is below 2^253 and cannot wrap modulo r.
+func assertLeavesMatchPreimage(api frontend.API, leaves, preimage []frontend.Variable) {
+	for i := range leaves {
+		top := bits.ToBinary(api, preimage[32*i], bits.WithNbDigits(8))
+		leaf := bits.FromBinary(api, top[:treeutils.FieldElemLoBits-8*31])
+		for j := 1; j < 32; j++ {
+			leaf = api.Add(api.Mul(leaf, 256), preimage[32*i+j])
+		}
+		api.AssertIsEqual(leaves[i], leaf)
+	}
+}
+
+// assertPadding constrains 1 <= nbLeaves <= len(preimage)/noteSize, and the notes of
+// preimage from nbLeaves on to be zero. The bytes of note hashes are range checked when they
+// are written to sha256, so they are all zero when their sum is.
+func assertPadding(api frontend.API, nbLeaves frontend.Variable, preimage []frontend.Variable, noteSize int) {
+	nbNotes := len(preimage) / noteSize
+	api.AssertIsDifferent(nbLeaves, 0)
+	api.AssertIsLessOrEqual(nbLeaves, nbNotes)
+	// padding is 1 from note nbLeaves on
+	padding := frontend.Variable(0)
+	for i := 1; i < nbNotes; i++ {
+		padding = api.Add(padding, api.IsZero(api.Sub(nbLeaves, i)))
+		sum := frontend.Variable(0)
+		for _, v := range preimage[noteSize*i : noteSize*(i+1)] {
+			sum = api.Add(sum, v)
+		}
+		api.AssertIsEqual(api.Mul(padding, sum), 0)
+	}
+}
+
+// accumulatorPreimage frames the note hashes in preimage as the BCS encoding of the
+// vector<vector<u8>> batch hashed by compute_accumulator_hash. The length prefixes only
+// depend on the batch size and are constants.
+func accumulatorPreimage(preimage []frontend.Variable) []frontend.Variable {
+	nbHashes := len(preimage) / 32
+	var res []frontend.Variable
+	for _, b := range bcs.AppendULEB128(nil, uint64(nbHashes)) {
+		res = append(res, b)
+	}
+	for i := 0; i < nbHashes; i++ {
+		for _, b := range bcs.AppendULEB128(nil, 32) {
+			res = append(res, b)
+		}
+		res = append(res, preimage[32*i:32*(i+1)]...)
+	}
+	return res
+}
+
+func (circuit *SubtreeUpdateCircuit) Define(api frontend.API) error {
+	if circuit.config == (Config{}) {
+		return fmt.Errorf("%w: circuit not built by NewCircuit", ErrConfig)
+	}
+	h := poseidon.NewPoseidonHash(api)
+	newRoot, _, err := circuit.config.updateSubtree(api, h, circuit.OldRoot, &BatchUpdate{
+		EncodedPathAndHash: circuit.EncodedPathAndHash,
+		AccumulatorHash:    circuit.AccumulatorHash,
+		Siblings:           circuit.Siblings,
+		Preimage:           circuit.Preimage,
+		Leaves:             circuit.Leaves,
+	})
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(circuit.NewRoot, newRoot)
+	return nil
+}
+
+// updateSubtree constrains b to fill the empty subtree of the tree of root oldRoot at the
+// path of b.EncodedPathAndHash, and returns the root of the updated tree and the subtree
+// index.
+func (c Config) updateSubtree(api frontend.API, h hash.Hash, oldRoot frontend.Variable, b *BatchUpdate) (newRoot, subtreeIndex frontend.Variable, err error) {
+	params := c.TreeParams()
+	if c.Accumulator == Poseidon {
+		// the preimage of the accumulator hash is the leaves
+		for i := range b.Leaves {
+			api.AssertIsEqual(b.Leaves[i], b.Preimage[i])
+		}
+	} else {
+		assertLeavesMatchPreimage(api, b.Leaves, b.Preimage)
+	}
+	subtreeRoot := params.ComputeRootFromLeaves(api, h, b.Leaves)
+	emptyTreeLeaves := make([]frontend.Variable, c.BatchSize)
+	for i := range emptyTreeLeaves {
+		emptyTreeLeaves[i] = 0
+	}
+	emptySubtreeRoot := params.ComputeRootFromLeaves(api, h, emptyTreeLeaves)
+
+	// the low pathBits bits are the subtree index, two bits (one base-4 digit) per level,
+	// the 3 next ones the top of a sha256 accumulator hash, and the last ones the number of
+	// notes of a partial batch
+	pathBits := c.pathBits()
+	hashBits := c.hashBits()
+	EncodedPathAndHashBits := bits.ToBinary(api, b.EncodedPathAndHash, bits.WithNbDigits(hashBits+c.nbLeavesBits()))
+	pathIndices := make([][]frontend.Variable, params.PathDepth())
+	for i := range pathIndices {
+		pathIndices[i] = []frontend.Variable{EncodedPathAndHashBits[2*i+1], EncodedPathAndHashBits[2*i]}
+	}
+	subtreeIndex = frontend.Variable(0)
+	for i := pathBits - 1; i >= 0; i-- {
+		subtreeIndex = api.Add(api.Mul(subtreeIndex, 2), EncodedPathAndHashBits[i])
+	}
+
+	if c.Partial {
+		assertPadding(api, bits.FromBinary(api, EncodedPathAndHashBits[hashBits:]), b.Preimage, c.preimageNoteSize())
+	}
+
+	if c.Accumulator == Poseidon {
+		api.AssertIsEqual(b.AccumulatorHash, poseidonAccumulator(api, b.Preimage))
+	} else {
+		if err := assertSha256Accumulator(api, b.AccumulatorHash, EncodedPathAndHashBits[pathBits:hashBits], b.Preimage); err != nil {
+			return nil, nil, err
+		}
+	}
+	// the same path leads from the empty subtree to oldRoot and from the filled one to newRoot
+	api.AssertIsEqual(oldRoot, merkle.ComputeRoot(api, h, emptySubtreeRoot, pathIndices, b.Siblings))
+	return merkle.ComputeRoot(api, h, subtreeRoot, pathIndices, b.Siblings), subtreeIndex, nil
+}
+
+// assertSha256Accumulator constrains the sha256 of the batch framed by accumulatorPreimage
+// to be the accumulator hash of low limb lo and top bits hi.
+func assertSha256Accumulator(api frontend.API, lo frontend.Variable, hi []frontend.Variable, preimage []frontend.Variable) error {
+	accumulatorHashBits := append(bits.ToBinary(api, lo, bits.WithNbDigits(treeutils.FieldElemLoBits)), hi...)
+	accumulatorHashBytes := make([]frontend.Variable, 32)
+	for i := 0; i < 32; i++ {
+		accumulatorHashBytes[i] = api.Add(
+			api.Mul(accumulatorHashBits[255-8*i], frontend.Variable("128")),
+			api.Mul(accumulatorHashBits[254-8*i], frontend.Variable("64")),
+			api.Mul(accumulatorHashBits[253-8*i], frontend.Variable("32")),
+			api.Mul(accumulatorHashBits[252-8*i], frontend.Variable("16")),
+			api.Mul(accumulatorHashBits[251-8*i], frontend.Variable("8")),
+			api.Mul(accumulatorHashBits[250-8*i], frontend.Variable("4")),
+			api.Mul(accumulatorHashBits[249-8*i], frontend.Variable("2")),
+			accumulatorHashBits[248-8*i],
+		)
+	}
+	sha256 := sha2_256.New(api)
+	sha256.Write(accumulatorPreimage(preimage)...)
+	result, err := sha256.Sum()
+	if err != nil {
+		return err
+	}
+	for i := range result {
+		api.AssertIsEqual(result[i], accumulatorHashBytes[i])
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package circuit
+
+import (
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"math/big"
+	"subtreeUpdate/tree"
+	"testing"
+)
+
+type leavesPreimageCircuit struct {
+	Leaves   [tree.BatchSize]frontend.Variable
+	Preimage [PreimageSize]frontend.Variable
+}
+
+func (c *leavesPreimageCircuit) Define(api frontend.API) error {
+	assertLeavesMatchPreimage(api, c.Leaves[:], c.Preimage[:])
+	return nil
+}
+
+func leavesPreimageAssignment(noteHashes [][32]byte) *leavesPreimageCircuit {
+	a := &leavesPreimageCircuit{}
+	for i, n := range noteHashes {
+		a.Leaves[i] = tree.NoteLeaf(n)
+		for j := range n {
+			a.Preimage[32*i+j] = n[j]
+		}
+	}
+	return a
+}
+
+func TestLeavesMatchPreimage(t *testing.T) {
+	assert := test.NewAssert(t)
+	opts := []test.TestingOption{test.WithCurves(ecc.BN254), test.WithBackends(backend.GROTH16)}
+	noteHashes := randomNoteHashes(0)
+
+	assert.ProverSucceeded(&leavesPreimageCircuit{}, leavesPreimageAssignment(noteHashes), opts...)
+
+	// note hashes above the field modulus are bound to their low 253 bits
+	overflowing := noteHashesFromDecimal(t, secondBatch)
+	assert.ProverSucceeded(&leavesPreimageCircuit{}, leavesPreimageAssignment(overflowing), opts...)
+
+	// and not to their value modulo r
+	wrapped := leavesPreimageAssignment(overflowing)
+	wrapped.Leaves[1] = new(big.Int).SetBytes(overflowing[1][:])
+	assert.ProverFailed(&leavesPreimageCircuit{}, wrapped, opts...)
+
+	wrongByte := leavesPreimageAssignment(noteHashes)
+	wrongByte.Preimage[PreimageSize-1] = (int(noteHashes[tree.BatchSize-1][31]) + 1) % 256
+	assert.ProverFailed(&leavesPreimageCircuit{}, wrongByte, opts...)
+
+	swapped := leavesPreimageAssignment(noteHashes)
+	swapped.Leaves[0], swapped.Leaves[1] = swapped.Leaves[1], swapped.Leaves[0]
+	assert.ProverFailed(&leavesPreimageCircuit{}, swapped, opts...)
+
+	other := leavesPreimageAssignment(noteHashes)
+	other.Leaves = leavesPreimageAssignment(randomNoteHashes(1)).Leaves
+	assert.ProverFailed(&leavesPreimageCircuit{}, other, opts...)
+}
+
+// TestPreimageBoundToLeaves forges an update that inserts leaves other than the ones
+// committed to by the accumulator hash.
+func TestPreimageBoundToLeaves(t *testing.T) {
+	tr := tree.New()
+	committed, err := BuildWitness(tr, randomNoteHashes(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inserted, err := BuildWitness(tr, randomNoteHashes(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged := *inserted.Assignment
+	forged.AccumulatorHash = committed.Assignment.AccumulatorHash
+	forged.EncodedPathAndHash = committed.Assignment.EncodedPathAndHash
+	forged.Preimage = committed.Assignment.Preimage
+	if err := test.IsSolved(NewSubtreeUpdateCircuit(), &forged, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("update inserting leaves that do not match the preimage was accepted")
+	}
+
+	// a single leaf differing from its preimage bytes
+	modified := clone(committed.Assignment)
+	modified.Leaves[3] = new(big.Int).Add(modified.Leaves[3].(*big.Int), big.NewInt(1))
+	if err := test.IsSolved(NewSubtreeUpdateCircuit(), modified, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("leaf differing from its preimage bytes was accepted")
+	}
+}
+
+func buildTree(t *testing.T, nbBatches, seed uint64) *tree.Tree {
+	tr := tree.New()
+	for i := uint64(0); i < nbBatches; i++ {
+		insertNoteHashes(t, tr, randomNoteHashes(seed+i))
+	}
+	return tr
+}
+
+// clone copies a, whose slices would be shared by a struct copy.
+func clone(a *SubtreeUpdateCircuit) *SubtreeUpdateCircuit {
+	res := *a
+	res.Siblings = make([][]frontend.Variable, len(a.Siblings))
+	for i := range a.Siblings {
+		res.Siblings[i] = append([]frontend.Variable{}, a.Siblings[i]...)
+	}
+	res.Preimage = append([]frontend.Variable{}, a.Preimage...)
+	res.Leaves = append([]frontend.Variable{}, a.Leaves...)
+	return &res
+}
+
+func assertNotSolved(t *testing.T, assignment *SubtreeUpdateCircuit, msg string) {
+	t.Helper()
+	if err := test.IsSolved(NewSubtreeUpdateCircuit(), assignment, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal(msg)
+	}
+}
+
+func TestSharedAuthenticationPath(t *testing.T) {
+	tr := buildTree(t, 5, 100)
+	w, err := BuildWitness(tr, randomNoteHashes(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.IsSolved(NewSubtreeUpdateCircuit(), w.Assignment, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+
+	// NewRoot of the same insertion into an unrelated tree, sharing no siblings
+	other, err := BuildWitness(buildTree(t, 5, 200), randomNoteHashes(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := *w.Assignment
+	forged.NewRoot = other.NewRoot
+	assertNotSolved(t, &forged, "new root derived from other siblings was accepted")
+
+	// siblings of another tree cannot reach OldRoot
+	forged = *w.Assignment
+	forged.Siblings = other.Assignment.Siblings
+	assertNotSolved(t, &forged, "siblings of another tree were accepted")
+
+	// a single wrong sibling
+	modified := clone(w.Assignment)
+	modified.Siblings[9][1] = big.NewInt(42)
+	assertNotSolved(t, modified, "modified sibling was accepted")
+
+	// the path is the public subtree index
+	forged = *w.Assignment
+	forged.EncodedPathAndHash = new(big.Int).Add(w.Assignment.EncodedPathAndHash.(*big.Int), big.NewInt(1))
+	assertNotSolved(t, &forged, "update at another subtree index was accepted")
+}
+
+func TestCannotOverwriteFilledSubtree(t *testing.T) {
+	tr := buildTree(t, 2, 100)
+	// rebuild the tree with subtree 0 replaced, and try to prove it as an insertion
+	overwritten := tree.New()
+	insertNoteHashes(t, overwritten, randomNoteHashes(0))
+	insertNoteHashes(t, overwritten, randomNoteHashes(101))
+	p, err := tr.ProveSubtree(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := p.MerkleProof()
+
+	w, err := BuildWitness(tree.New(), randomNoteHashes(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := *w.Assignment
+	forged.OldRoot = tr.Root()
+	forged.NewRoot = overwritten.Root()
+	forged.Siblings = path.Siblings
+	assertNotSolved(t, &forged, "overwriting a filled subtree was accepted")
+}
+
+func TestPartialBatch(t *testing.T) {
+	partial, err := NewCircuit(Config{Depth: tree.Depth, BatchSize: tree.BatchSize, Partial: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := buildTree(t, 1, 100)
+	for _, k := range []int{1, 5, 15, 16} {
+		w, err := BuildPartialWitness(tr, randomNoteHashes(0)[:k])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.NbLeaves != k || w.SubtreeIndex != 1 || w.AccumulatorHash.Cmp(PaddedAccumulatorHash(randomNoteHashes(0)[:k], tree.BatchSize)) != 0 {
+			t.Fatalf("%d notes: witness of %d notes in subtree %d", k, w.NbLeaves, w.SubtreeIndex)
+		}
+		// k follows the subtree index and the top 3 bits of the hash
+		if got := new(big.Int).Rsh(w.Assignment.EncodedPathAndHash.(*big.Int), 31); got.Int64() != int64(k) {
+			t.Fatalf("%d notes: encoded number of notes %s", k, got)
+		}
+		if err := test.IsSolved(partial, w.Assignment, ecc.BN254.ScalarField()); err != nil {
+			t.Fatalf("%d notes: %v", k, err)
+		}
+	}
+
+	// a full batch is the same update in both circuits
+	full, err := BuildWitness(tr, randomNoteHashes(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := BuildPartialWitness(tr, randomNoteHashes(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.NewRoot.Cmp(full.NewRoot) != 0 || w.AccumulatorHash.Cmp(full.AccumulatorHash) != 0 {
+		t.Fatal("partial witness of a full batch differs from the full witness")
+	}
+	// but the full circuit has no room for the number of notes
+	assertNotSolved(t, w.Assignment, "partial witness accepted by the full circuit")
+
+	w, err = BuildPartialWitness(tr, randomNoteHashes(0)[:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	withNbLeaves := func(k int64) *SubtreeUpdateCircuit {
+		forged := clone(w.Assignment)
+		encoded := new(big.Int).And(w.Assignment.EncodedPathAndHash.(*big.Int), new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 31), big.NewInt(1)))
+		forged.EncodedPathAndHash = encoded.Or(encoded, new(big.Int).Lsh(big.NewInt(k), 31))
+		return forged
+	}
+	for _, c := range []struct {
+		k   int64
+		msg string
+	}{
+		{4, "a real note was hidden in the padding"},
+		{0, "an empty batch was accepted"},
+		{17, "more notes than a batch holds were accepted"},
+	} {
+		if err := test.IsSolved(partial, withNbLeaves(c.k), ecc.BN254.ScalarField()); err == nil {
+			t.Fatal(c.msg)
+		}
+	}
+	if err := test.IsSolved(partial, withNbLeaves(5), ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := BuildPartialWitness(tr, nil); err != tree.ErrBatchSize {
+		t.Fatalf("got %v, want %v", err, tree.ErrBatchSize)
+	}
+	if _, err := BuildPartialWitness(tr, append(randomNoteHashes(0), randomNoteHashes(1)[0])); err != tree.ErrBatchSize {
+		t.Fatalf("got %v, want %v", err, tree.ErrBatchSize)
+	}
+}
@@ -0,0 +1,146 @@
+package circuit
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"subtreeUpdate/merkle"
+	"subtreeUpdate/tree"
+	"subtreeUpdate/treeutils"
+)
+
+var ErrConfig = errors.New("circuit: invalid configuration")
+
+// Config is the shape of a subtree update circuit: the depth of the quadtree and the number
+// of leaves inserted by one update. Larger batches cost more to prove but fewer updates
+// on-chain. The Move contract must be deployed with the same DEPTH and BATCH_SIZE.
+//
+// Constraints per configuration, printed by the compile -table command of the prover CLI.
+// Nearly all of the constraints of the sha256 accumulator are spent in sha256: with
+// DefaultConfig, BenchmarkAccumulators of the prover package proves the Poseidon
+// accumulator about 8 times faster.
+//
+//	depth  batch  constraints
+//	16     4        126,535
+//	16     16       363,195
+//	16     64     1,350,435
+//	20     16       365,659
+//	31     16       372,435
+//	16     16       363,516  partial
+//	16     16        10,738  poseidon
+//	16     64        16,758  poseidon
+//	16     16       726,390  2 batches, see MultiSubtreeUpdateCircuit
+//	16     16     1,452,780  4 batches
+type Config struct {
+	Depth     int
+	BatchSize int
+	// Partial circuits insert batches of 1 to BatchSize notes, padded with zero leaves, so
+	// that a batch can be flushed before it is full. The accumulator hash is the one of the
+	// batch padded with zero note hashes, see PaddedAccumulatorHash, and the number of notes
+	// is committed to in encodedPathAndHash, see Config.PublicInputs. The subtree is filled
+	// either way: the next update starts at the next subtree.
+	Partial bool
+	// Accumulator is the hash of the batch, compute_accumulator_hash unless Poseidon.
+	Accumulator Accumulator
+}
+
+// DefaultConfig is the configuration of libs::tree_utils.
+var DefaultConfig = Config{Depth: tree.Depth, BatchSize: tree.BatchSize}
+
+// Configs are the configurations of the table of Config.
+var Configs = []Config{
+	{Depth: 16, BatchSize: 4},
+	DefaultConfig,
+	{Depth: 16, BatchSize: 64},
+	{Depth: 20, BatchSize: 16},
+	{Depth: 31, BatchSize: 16},
+	{Depth: 16, BatchSize: 16, Partial: true},
+	{Depth: 16, BatchSize: 16, Accumulator: Poseidon},
+	{Depth: 16, BatchSize: 64, Accumulator: Poseidon},
+}
+
+func (c Config) String() string {
+	s := fmt.Sprintf("depth %d, batch %d", c.Depth, c.BatchSize)
+	if c.Partial {
+		s = fmt.Sprintf("depth %d, partial batch %d", c.Depth, c.BatchSize)
+	}
+	if c.Accumulator != SHA256 {
+		s += fmt.Sprintf(", %s accumulator", c.Accumulator)
+	}
+	return s
+}
+
+// Validate checks that the batch size is a power of 4, that a batch fits in the tree and
+// that the accumulator is known.
+func (c Config) Validate() error {
+	subtreeDepth := c.TreeParams().SubtreeDepth
+	if c.BatchSize < tree.Arity || c.TreeParams().SubtreeSize() != c.BatchSize {
+		return fmt.Errorf("%w: batch size %d is not a power of %d", ErrConfig, c.BatchSize, tree.Arity)
+	}
+	if _, err := tree.NewWithParams(c.TreeParams()); err != nil {
+		return fmt.Errorf("%w: %v", ErrConfig, err)
+	}
+	if c.Accumulator != SHA256 && c.Accumulator != Poseidon {
+		return fmt.Errorf("%w: unknown accumulator %d", ErrConfig, c.Accumulator)
+	}
+	if c.Depth <= subtreeDepth {
+		return fmt.Errorf("%w: a batch of %d fills a tree of depth %d", ErrConfig, c.BatchSize, c.Depth)
+	}
+	return nil
+}
+
+// TreeParams returns the shape of the tree updated by the circuit.
+func (c Config) TreeParams() merkle.Params {
+	subtreeDepth := 0
+	for n := 1; n < c.BatchSize; n *= tree.Arity {
+		subtreeDepth++
+	}
+	return merkle.Params{Arity: tree.Arity, Depth: c.Depth, SubtreeDepth: subtreeDepth}
+}
+
+// ConfigOf returns the configuration updating trees of shape p.
+func ConfigOf(p merkle.Params) Config {
+	return Config{Depth: p.Depth, BatchSize: p.SubtreeSize()}
+}
+
+// PreimageSize is the size of the preimage of the accumulator hash: the bytes of the note
+// hashes of a batch, or its leaves with the Poseidon accumulator.
+func (c Config) PreimageSize() int {
+	return c.BatchSize * c.preimageNoteSize()
+}
+
+// preimageNoteSize is the size of the preimage of one note.
+func (c Config) preimageNoteSize() int {
+	if c.Accumulator == Poseidon {
+		return 1
+	}
+	return 32
+}
+
+// pathBits is the size of the subtree index in encodedPathAndHash.
+func (c Config) pathBits() int {
+	return c.pathAndHash().PathBits()
+}
+
+// hashBits is the size of the subtree index and the top of the accumulator hash in
+// encodedPathAndHash. A Poseidon accumulator hash fits in the accumulatorHash input.
+func (c Config) hashBits() int {
+	if c.Accumulator == Poseidon {
+		return c.pathBits()
+	}
+	return c.pathBits() + 256 - treeutils.FieldElemLoBits
+}
+
+// nbLeavesBits is the size of the number of notes of a partial batch in encodedPathAndHash,
+// 0 unless c.Partial.
+func (c Config) nbLeavesBits() int {
+	if !c.Partial {
+		return 0
+	}
+	return bits.Len(uint(c.BatchSize))
+}
+
+func (c Config) pathAndHash() treeutils.Params {
+	p := c.TreeParams()
+	return treeutils.Params{Depth: p.Depth, BatchSize: uint64(c.BatchSize), BatchSubtreeDepth: p.SubtreeDepth}
+}
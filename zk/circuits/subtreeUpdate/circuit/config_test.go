@@ -0,0 +1,118 @@
+package circuit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/test"
+	"subtreeUpdate/tree"
+	"testing"
+)
+
+func noteHashes(seed uint64, n int) [][32]byte {
+	res := make([][32]byte, n)
+	for i := range res {
+		res[i] = sha256.Sum256(binary.BigEndian.AppendUint64(nil, seed*uint64(n)+uint64(i)))
+	}
+	return res
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, c := range append([]Config{{Depth: 3, BatchSize: 4}, {Depth: 4, BatchSize: 64}}, Configs...) {
+		if err := c.Validate(); err != nil {
+			t.Errorf("%s: %v", c, err)
+		}
+	}
+	for _, c := range []Config{
+		{},
+		{Depth: 16, BatchSize: 8},
+		{Depth: 16, BatchSize: 1},
+		{Depth: 16, BatchSize: -4},
+		{Depth: 2, BatchSize: 16},
+		{Depth: 1, BatchSize: 16},
+		{Depth: 33, BatchSize: 16},
+	} {
+		if err := c.Validate(); !errors.Is(err, ErrConfig) {
+			t.Errorf("%s: got %v, want %v", c, err, ErrConfig)
+		}
+		if _, err := NewCircuit(c); err == nil {
+			t.Errorf("%s: circuit built", c)
+		}
+	}
+	if ConfigOf(tree.Params) != DefaultConfig || DefaultConfig.TreeParams() != tree.Params {
+		t.Fatalf("DefaultConfig %+v does not match tree.Params %+v", DefaultConfig, tree.Params)
+	}
+}
+
+// TestConfigsAreSolved fills small trees of every batch size with the witnesses of
+// BuildWitness.
+func TestConfigsAreSolved(t *testing.T) {
+	for _, c := range []Config{
+		{Depth: 3, BatchSize: 4},
+		{Depth: 5, BatchSize: 16},
+		{Depth: 4, BatchSize: 64},
+	} {
+		tr, err := tree.NewWithParams(c.TreeParams())
+		if err != nil {
+			t.Fatal(err)
+		}
+		circuit, err := NewCircuit(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nbBatches := 1 << (2 * (c.Depth - c.TreeParams().SubtreeDepth))
+		for i := 0; i < nbBatches; i++ {
+			batch := noteHashes(uint64(i), c.BatchSize)
+			w, err := BuildWitness(tr, batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.Config != c || w.SubtreeIndex != uint64(i) {
+				t.Fatalf("%s: witness of %s, subtree %d", c, w.Config, w.SubtreeIndex)
+			}
+			// the last subtrees of small trees, only the first ones of the larger
+			if i < 2 || i >= nbBatches-2 {
+				if err := test.IsSolved(circuit, w.Assignment, ecc.BN254.ScalarField()); err != nil {
+					t.Fatalf("%s, batch %d: %v", c, i, err)
+				}
+			}
+			insertNoteHashes(t, tr, batch)
+		}
+		if _, err := BuildWitness(tr, noteHashes(0, c.BatchSize)); err != tree.ErrTreeFull {
+			t.Fatalf("%s: got %v, want %v", c, err, tree.ErrTreeFull)
+		}
+	}
+
+	// the deepest trees have 2^64 leaves
+	deepest := Config{Depth: 32, BatchSize: 16}
+	tr, err := tree.NewWithParams(deepest.TreeParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deepest.BuildWitness(tr, noteHashes(0, deepest.BatchSize)); err != nil {
+		t.Fatalf("%s: %v", deepest, err)
+	}
+
+	// a witness of the default configuration does not fit the others
+	w, err := BuildWitness(tree.New(), noteHashes(0, tree.BatchSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := NewCircuit(Config{Depth: 16, BatchSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.IsSolved(small, w.Assignment, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("witness of another configuration accepted")
+	}
+}
+
+func TestDefineRequiresConfig(t *testing.T) {
+	_, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &SubtreeUpdateCircuit{})
+	if !errors.Is(err, ErrConfig) {
+		t.Fatalf("got %v, want %v", err, ErrConfig)
+	}
+}
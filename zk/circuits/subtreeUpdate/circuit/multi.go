@@ -0,0 +1,190 @@
+package circuit
+
+import (
+	"fmt"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"math/big"
+	"subtreeUpdate/poseidon"
+	"subtreeUpdate/tree"
+)
+
+// BatchUpdate is the insertion of one batch by a MultiSubtreeUpdateCircuit, with the fields
+// of SubtreeUpdateCircuit of the same name.
+type BatchUpdate struct {
+	EncodedPathAndHash frontend.Variable     `gnark:"encodedPathAndHash,public"`
+	AccumulatorHash    frontend.Variable     `gnark:"accumulatorHash,public"`
+	Siblings           [][]frontend.Variable `gnark:"siblings,secret"`
+	Preimage           []frontend.Variable   `gnark:"preImage"`
+	Leaves             []frontend.Variable   `gnark:"leaves,secret"`
+}
+
+// MultiSubtreeUpdateCircuit inserts several queued batches into adjacent subtrees in one
+// proof: batch i goes from the root left by batch i-1 into the next subtree. Its public
+// inputs are oldRoot, newRoot and the encodedPathAndHash and accumulatorHash of every batch,
+// in queue order, see MultiPublicInputs.
+type MultiSubtreeUpdateCircuit struct {
+	config  Config
+	OldRoot frontend.Variable `gnark:"oldRoot,public"`
+	NewRoot frontend.Variable `gnark:"newRoot,public"`
+	Batches []BatchUpdate
+}
+
+// NewMultiCircuit returns a circuit inserting nbBatches batches of configuration c, ready
+// to be compiled or assigned. Partial batches cannot be combined.
+func NewMultiCircuit(c Config, nbBatches int) (*MultiSubtreeUpdateCircuit, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if c.Partial {
+		return nil, fmt.Errorf("%w: partial batches cannot be combined", ErrConfig)
+	}
+	if nbBatches < 1 {
+		return nil, fmt.Errorf("%w: %d batches", ErrConfig, nbBatches)
+	}
+	circuit := &MultiSubtreeUpdateCircuit{config: c, Batches: make([]BatchUpdate, nbBatches)}
+	for i := range circuit.Batches {
+		circuit.Batches[i] = BatchUpdate{
+			Siblings: c.TreeParams().NewSubtreeProof().Siblings,
+			Preimage: make([]frontend.Variable, c.PreimageSize()),
+			Leaves:   make([]frontend.Variable, c.BatchSize),
+		}
+	}
+	return circuit, nil
+}
+
+// Config returns the configuration of the batches of the circuit.
+func (circuit *MultiSubtreeUpdateCircuit) Config() Config {
+	return circuit.config
+}
+
+func (circuit *MultiSubtreeUpdateCircuit) Define(api frontend.API) error {
+	if circuit.config == (Config{}) {
+		return fmt.Errorf("%w: circuit not built by NewMultiCircuit", ErrConfig)
+	}
+	h := poseidon.NewPoseidonHash(api)
+	root := circuit.OldRoot
+	var previous frontend.Variable
+	for i := range circuit.Batches {
+		var subtreeIndex frontend.Variable
+		var err error
+		root, subtreeIndex, err = circuit.config.updateSubtree(api, h, root, &circuit.Batches[i])
+		if err != nil {
+			return err
+		}
+		// the subtrees are adjacent, as the batches are in the queue
+		if i > 0 {
+			api.AssertIsEqual(subtreeIndex, api.Add(previous, 1))
+		}
+		previous = subtreeIndex
+	}
+	api.AssertIsEqual(circuit.NewRoot, root)
+	return nil
+}
+
+// MultiWitness is a complete assignment of MultiSubtreeUpdateCircuit.
+type MultiWitness struct {
+	Config     Config
+	Assignment *MultiSubtreeUpdateCircuit
+	// SubtreeIndex is the subtree of the first batch.
+	SubtreeIndex      uint64
+	AccumulatorHashes []*big.Int
+	OldRoot           *big.Int
+	NewRoot           *big.Int
+	Full              witness.Witness
+	Public            witness.Witness
+}
+
+// MultiPublicInputs returns the public inputs of the update of len(accumulatorHashes)
+// batches from subtree subtreeIndex on: oldRoot, newRoot, then the encodedPathAndHash and
+// the low limb of the accumulator hash of every batch, as apply_subtree_updates pushes them.
+func (c Config) MultiPublicInputs(oldRoot, newRoot *big.Int, subtreeIndex uint64, accumulatorHashes []*big.Int) ([]*big.Int, error) {
+	res := []*big.Int{oldRoot, newRoot}
+	for i, h := range accumulatorHashes {
+		in, err := c.PublicInputs(oldRoot, newRoot, subtreeIndex+uint64(i), h)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, in[2:]...)
+	}
+	return res, nil
+}
+
+// MultiPublicWitness returns the public witness of the update of len(accumulatorHashes)
+// batches, see MultiPublicInputs.
+func (c Config) MultiPublicWitness(oldRoot, newRoot *big.Int, subtreeIndex uint64, accumulatorHashes []*big.Int) (witness.Witness, error) {
+	in, err := c.MultiPublicInputs(oldRoot, newRoot, subtreeIndex, accumulatorHashes)
+	if err != nil {
+		return nil, err
+	}
+	assignment, err := NewMultiCircuit(c, len(accumulatorHashes))
+	if err != nil {
+		return nil, err
+	}
+	assignment.OldRoot = in[0]
+	assignment.NewRoot = in[1]
+	for i := range assignment.Batches {
+		assignment.Batches[i].EncodedPathAndHash = in[2+2*i]
+		assignment.Batches[i].AccumulatorHash = in[3+2*i]
+	}
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return nil, fmt.Errorf("circuit: public witness: %w", err)
+	}
+	return w, nil
+}
+
+// BuildMultiWitness assigns MultiSubtreeUpdateCircuit for the insertion of batches as the
+// next batches of t. t is not modified.
+func BuildMultiWitness(t *tree.Tree, batches [][][32]byte) (*MultiWitness, error) {
+	return ConfigOf(t.Params()).BuildMultiWitness(t, batches)
+}
+
+// BuildMultiWitness assigns the MultiSubtreeUpdateCircuit of configuration c for the
+// insertion of batches as the next batches of t, see Config.BuildWitness.
+func (c Config) BuildMultiWitness(t *tree.Tree, batches [][][32]byte) (*MultiWitness, error) {
+	assignment, err := NewMultiCircuit(c, len(batches))
+	if err != nil {
+		return nil, err
+	}
+	next := t.Clone()
+	w := &MultiWitness{
+		Config:       c,
+		Assignment:   assignment,
+		SubtreeIndex: t.Count() / uint64(c.BatchSize),
+		OldRoot:      t.Root(),
+	}
+	for i, noteHashes := range batches {
+		single, err := c.BuildWitness(next, noteHashes)
+		if err != nil {
+			return nil, fmt.Errorf("circuit: batch %d: %w", i, err)
+		}
+		leaves := make([]*big.Int, len(noteHashes))
+		for j, n := range noteHashes {
+			leaves[j] = tree.NoteLeaf(n)
+		}
+		if err := next.InsertBatch(leaves); err != nil {
+			return nil, err
+		}
+		assignment.Batches[i] = BatchUpdate{
+			EncodedPathAndHash: single.Assignment.EncodedPathAndHash,
+			AccumulatorHash:    single.Assignment.AccumulatorHash,
+			Siblings:           single.Assignment.Siblings,
+			Preimage:           single.Assignment.Preimage,
+			Leaves:             single.Assignment.Leaves,
+		}
+		w.AccumulatorHashes = append(w.AccumulatorHashes, single.AccumulatorHash)
+	}
+	w.NewRoot = next.Root()
+	assignment.OldRoot = w.OldRoot
+	assignment.NewRoot = w.NewRoot
+
+	if w.Full, err = frontend.NewWitness(assignment, ecc.BN254.ScalarField()); err != nil {
+		return nil, fmt.Errorf("circuit: witness: %w", err)
+	}
+	if w.Public, err = w.Full.Public(); err != nil {
+		return nil, fmt.Errorf("circuit: public witness: %w", err)
+	}
+	return w, nil
+}
@@ -0,0 +1,109 @@
+package circuit
+
+import (
+	"bytes"
+	"errors"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/test"
+	"math/big"
+	"subtreeUpdate/tree"
+	"testing"
+)
+
+func TestMultiBatch(t *testing.T) {
+	tr := buildTree(t, 2, 100)
+	batches := [][][32]byte{randomNoteHashes(0), randomNoteHashes(1), randomNoteHashes(2)}
+	w, err := BuildMultiWitness(tr, batches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	circuit, err := NewMultiCircuit(DefaultConfig, len(batches))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.IsSolved(circuit, w.Assignment, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same roots and inputs as one update per batch
+	next := tr.Clone()
+	for i, b := range batches {
+		single, err := BuildWitness(next, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if single.AccumulatorHash.Cmp(w.AccumulatorHashes[i]) != 0 || single.Assignment.EncodedPathAndHash.(*big.Int).Cmp(w.Assignment.Batches[i].EncodedPathAndHash.(*big.Int)) != 0 {
+			t.Fatalf("batch %d: public inputs differ from its single update", i)
+		}
+		insertNoteHashes(t, next, b)
+	}
+	if w.SubtreeIndex != 2 || w.OldRoot.Cmp(tr.Root()) != 0 || w.NewRoot.Cmp(next.Root()) != 0 {
+		t.Fatalf("update of subtree %d from %s to %s", w.SubtreeIndex, w.OldRoot, w.NewRoot)
+	}
+
+	public, err := DefaultConfig.MultiPublicWitness(w.OldRoot, w.NewRoot, w.SubtreeIndex, w.AccumulatorHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := public.MarshalBinary()
+	want, _ := w.Public.MarshalBinary()
+	if !bytes.Equal(got, want) {
+		t.Fatal("MultiPublicWitness differs from the public part of the witness")
+	}
+	in, err := DefaultConfig.MultiPublicInputs(w.OldRoot, w.NewRoot, w.SubtreeIndex, w.AccumulatorHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(in) != 2+2*len(batches) {
+		t.Fatalf("%d public inputs", len(in))
+	}
+
+	// batches proven out of queue order
+	swapped := *w.Assignment
+	swapped.Batches = append([]BatchUpdate{}, w.Assignment.Batches...)
+	swapped.Batches[1], swapped.Batches[2] = swapped.Batches[2], swapped.Batches[1]
+	if err := test.IsSolved(circuit, &swapped, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("batches out of order were accepted")
+	}
+
+	// skipping a subtree, with the siblings of the skipped tree
+	skipping, err := BuildMultiWitness(tr, [][][32]byte{randomNoteHashes(0), randomNoteHashes(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gap := tr.Clone()
+	insertNoteHashes(t, gap, randomNoteHashes(0))
+	insertNoteHashes(t, gap, make([][32]byte, tree.BatchSize))
+	last, err := BuildWitness(gap, randomNoteHashes(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipping.Assignment.Batches[1].EncodedPathAndHash = last.Assignment.EncodedPathAndHash
+	skipping.Assignment.Batches[1].Siblings = last.Assignment.Siblings
+	skipping.Assignment.NewRoot = last.NewRoot
+	pair, err := NewMultiCircuit(DefaultConfig, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.IsSolved(pair, skipping.Assignment, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("update skipping a subtree was accepted")
+	}
+}
+
+func TestNewMultiCircuit(t *testing.T) {
+	for _, c := range []struct {
+		config    Config
+		nbBatches int
+	}{
+		{DefaultConfig, 0},
+		{Config{Depth: tree.Depth, BatchSize: tree.BatchSize, Partial: true}, 2},
+		{Config{Depth: tree.Depth, BatchSize: 8}, 2},
+	} {
+		if _, err := NewMultiCircuit(c.config, c.nbBatches); !errors.Is(err, ErrConfig) {
+			t.Errorf("%s, %d batches: got %v, want %v", c.config, c.nbBatches, err, ErrConfig)
+		}
+	}
+	if _, err := BuildMultiWitness(tree.New(), [][][32]byte{randomNoteHashes(0), randomNoteHashes(1)[:3]}); !errors.Is(err, tree.ErrBatchSize) {
+		t.Fatalf("got %v, want %v", err, tree.ErrBatchSize)
+	}
+}
@@ -0,0 +1,223 @@
+package circuit
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"math/big"
+	"subtreeUpdate/bcs"
+	"subtreeUpdate/tree"
+	"subtreeUpdate/treeutils"
+)
+
+// Witness is a complete assignment of SubtreeUpdateCircuit.
+type Witness struct {
+	Config       Config
+	Assignment   *SubtreeUpdateCircuit
+	SubtreeIndex uint64
+	// NbLeaves is the number of notes of the batch, Config.BatchSize unless the batch is
+	// partial.
+	NbLeaves        int
+	AccumulatorHash *big.Int
+	OldRoot         *big.Int
+	NewRoot         *big.Int
+	Full            witness.Witness
+	Public          witness.Witness
+}
+
+// AccumulatorHash mirrors compute_accumulator_hash for a batch of note hashes: the sha256
+// of the BCS encoding of the batch.
+func AccumulatorHash(noteHashes [][32]byte) *big.Int {
+	h := sha256.Sum256(bcs.EncodeBatch(noteHashes))
+	return new(big.Int).SetBytes(h[:])
+}
+
+// AccumulatorHash returns the accumulator hash of a batch with the accumulator of c.
+func (c Config) AccumulatorHash(noteHashes [][32]byte) *big.Int {
+	if c.Accumulator == Poseidon {
+		return PoseidonAccumulatorHash(noteHashes)
+	}
+	return AccumulatorHash(noteHashes)
+}
+
+// PadBatch returns noteHashes followed by zero hashes up to batchSize.
+func PadBatch(noteHashes [][32]byte, batchSize int) [][32]byte {
+	res := make([][32]byte, batchSize)
+	copy(res, noteHashes)
+	return res
+}
+
+// PaddedAccumulatorHash is the accumulator hash of a partial batch, the AccumulatorHash of
+// the batch padded to batchSize.
+func PaddedAccumulatorHash(noteHashes [][32]byte, batchSize int) *big.Int {
+	return AccumulatorHash(PadBatch(noteHashes, batchSize))
+}
+
+// PublicInputs returns the public inputs of the update of subtree subtreeIndex, in the
+// order pushed by calculate_public_inputs and verified on-chain: oldRoot, newRoot,
+// encodedPathAndHash and the low limb of the accumulator hash.
+func PublicInputs(oldRoot, newRoot *big.Int, subtreeIndex uint64, accumulatorHash *big.Int) ([]*big.Int, error) {
+	return DefaultConfig.PublicInputs(oldRoot, newRoot, subtreeIndex, accumulatorHash)
+}
+
+// PublicInputs returns the public inputs of the update of a full batch with configuration
+// c, see PublicInputs.
+func (c Config) PublicInputs(oldRoot, newRoot *big.Int, subtreeIndex uint64, accumulatorHash *big.Int) ([]*big.Int, error) {
+	return c.PartialPublicInputs(oldRoot, newRoot, subtreeIndex, accumulatorHash, c.BatchSize)
+}
+
+// PartialPublicInputs returns the public inputs of the update of a batch of nbLeaves notes.
+// With a partial configuration, nbLeaves follows the top of the accumulator hash in
+// encodedPathAndHash. A Poseidon accumulator hash is not split: its top is empty.
+func (c Config) PartialPublicInputs(oldRoot, newRoot *big.Int, subtreeIndex uint64, accumulatorHash *big.Int, nbLeaves int) ([]*big.Int, error) {
+	if nbLeaves < 1 || nbLeaves > c.BatchSize || (!c.Partial && nbLeaves != c.BatchSize) {
+		return nil, tree.ErrBatchSize
+	}
+	hi, lo := treeutils.U256ToFieldElemLimbs(accumulatorHash)
+	if c.Accumulator == Poseidon {
+		hi, lo = new(big.Int), accumulatorHash
+	}
+	encoded, err := c.pathAndHash().EncodePathAndHash(subtreeIndex*uint64(c.BatchSize), hi)
+	if err != nil {
+		return nil, err
+	}
+	if c.Partial {
+		encoded.Or(encoded, new(big.Int).Lsh(big.NewInt(int64(nbLeaves)), uint(c.hashBits())))
+	}
+	return []*big.Int{oldRoot, newRoot, encoded, lo}, nil
+}
+
+// PublicWitness returns the public witness of the update of subtree subtreeIndex, to
+// verify a proof without building the full witness.
+func PublicWitness(oldRoot, newRoot *big.Int, subtreeIndex uint64, accumulatorHash *big.Int) (witness.Witness, error) {
+	return DefaultConfig.PublicWitness(oldRoot, newRoot, subtreeIndex, accumulatorHash)
+}
+
+// PublicWitness returns the public witness of the update of a full batch with
+// configuration c, see PublicWitness.
+func (c Config) PublicWitness(oldRoot, newRoot *big.Int, subtreeIndex uint64, accumulatorHash *big.Int) (witness.Witness, error) {
+	return c.PartialPublicWitness(oldRoot, newRoot, subtreeIndex, accumulatorHash, c.BatchSize)
+}
+
+// PartialPublicWitness returns the public witness of the update of a batch of nbLeaves
+// notes, see PartialPublicInputs.
+func (c Config) PartialPublicWitness(oldRoot, newRoot *big.Int, subtreeIndex uint64, accumulatorHash *big.Int, nbLeaves int) (witness.Witness, error) {
+	in, err := c.PartialPublicInputs(oldRoot, newRoot, subtreeIndex, accumulatorHash, nbLeaves)
+	if err != nil {
+		return nil, err
+	}
+	assignment, err := NewCircuit(c)
+	if err != nil {
+		return nil, err
+	}
+	assignment.OldRoot = in[0]
+	assignment.NewRoot = in[1]
+	assignment.EncodedPathAndHash = in[2]
+	assignment.AccumulatorHash = in[3]
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return nil, fmt.Errorf("circuit: public witness: %w", err)
+	}
+	return w, nil
+}
+
+// BuildWitness assigns SubtreeUpdateCircuit for the insertion of noteHashes as the next
+// batch of t. t is not modified. The configuration of the circuit is the one of the shape
+// of t.
+func BuildWitness(t *tree.Tree, noteHashes [][32]byte) (*Witness, error) {
+	return ConfigOf(t.Params()).BuildWitness(t, noteHashes)
+}
+
+// BuildPartialWitness assigns the partial SubtreeUpdateCircuit for the insertion of 1 to
+// t.BatchSize() noteHashes, padded with zero leaves, as the next batch of t. t is not
+// modified: insert PadBatch(noteHashes, t.BatchSize()) to keep it in sync with the proven
+// tree.
+func BuildPartialWitness(t *tree.Tree, noteHashes [][32]byte) (*Witness, error) {
+	c := ConfigOf(t.Params())
+	c.Partial = true
+	return c.BuildWitness(t, noteHashes)
+}
+
+// BuildWitness assigns the SubtreeUpdateCircuit of configuration c for the insertion of
+// noteHashes as the next batch of t, a tree of the shape of c. t is not modified.
+func (c Config) BuildWitness(t *tree.Tree, noteHashes [][32]byte) (*Witness, error) {
+	if t.Params() != c.TreeParams() {
+		return nil, fmt.Errorf("%w: %s does not update trees of shape %+v", ErrConfig, c, t.Params())
+	}
+	if len(noteHashes) < 1 || len(noteHashes) > c.BatchSize || (!c.Partial && len(noteHashes) != c.BatchSize) {
+		return nil, tree.ErrBatchSize
+	}
+	return buildWitness(c, t, noteHashes)
+}
+
+func buildWitness(c Config, t *tree.Tree, noteHashes [][32]byte) (*Witness, error) {
+	assignment, err := NewCircuit(c)
+	if err != nil {
+		return nil, err
+	}
+	if t.Count()%uint64(c.BatchSize) != 0 {
+		return nil, tree.ErrBatchAligned
+	}
+	subtreeIndex := t.Count() / uint64(c.BatchSize)
+	if subtreeIndex>>c.pathBits() != 0 {
+		return nil, tree.ErrTreeFull
+	}
+
+	emptyProof, err := t.ProveSubtree(subtreeIndex)
+	if err != nil {
+		return nil, err
+	}
+	path := emptyProof.MerkleProof()
+	padded := PadBatch(noteHashes, c.BatchSize)
+	next := t.Clone()
+	leaves := make([]*big.Int, len(padded))
+	for i, n := range padded {
+		leaves[i] = tree.NoteLeaf(n)
+	}
+	if err := next.InsertBatch(leaves); err != nil {
+		return nil, err
+	}
+
+	// the sha256 hash of a full batch is the one of compute_accumulator_hash
+	accumulatorHash := c.AccumulatorHash(padded)
+	public, err := c.PartialPublicInputs(t.Root(), next.Root(), subtreeIndex, accumulatorHash, len(noteHashes))
+	if err != nil {
+		return nil, err
+	}
+
+	assignment.OldRoot = public[0]
+	assignment.NewRoot = public[1]
+	assignment.EncodedPathAndHash = public[2]
+	assignment.AccumulatorHash = public[3]
+	// filling the subtree leaves its siblings unchanged
+	assignment.Siblings = path.Siblings
+	for i, n := range padded {
+		if c.Accumulator == Poseidon {
+			assignment.Preimage[i] = leaves[i]
+		} else {
+			for j := range n {
+				assignment.Preimage[32*i+j] = n[j]
+			}
+		}
+		assignment.Leaves[i] = leaves[i]
+	}
+
+	w := &Witness{
+		Config:          c,
+		Assignment:      assignment,
+		SubtreeIndex:    subtreeIndex,
+		NbLeaves:        len(noteHashes),
+		AccumulatorHash: accumulatorHash,
+		OldRoot:         t.Root(),
+		NewRoot:         next.Root(),
+	}
+	if w.Full, err = frontend.NewWitness(assignment, ecc.BN254.ScalarField()); err != nil {
+		return nil, fmt.Errorf("circuit: witness: %w", err)
+	}
+	if w.Public, err = w.Full.Public(); err != nil {
+		return nil, fmt.Errorf("circuit: public witness: %w", err)
+	}
+	return w, nil
+}
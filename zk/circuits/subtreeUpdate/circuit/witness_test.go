@@ -0,0 +1,244 @@
+package circuit
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"math/big"
+	"strings"
+	"subtreeUpdate/bcs"
+	"subtreeUpdate/tree"
+	"testing"
+)
+
+func noteHashesFromDecimal(t *testing.T, in []string) [][32]byte {
+	res := make([][32]byte, len(in))
+	for i, s := range in {
+		b, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			t.Fatalf("invalid integer %q", s)
+		}
+		b.FillBytes(res[i][:])
+	}
+	return res
+}
+
+func randomNoteHashes(seed uint64) [][32]byte {
+	res := make([][32]byte, tree.BatchSize)
+	for i := range res {
+		res[i] = sha256.Sum256(binary.BigEndian.AppendUint64(nil, seed*tree.BatchSize+uint64(i)))
+	}
+	return res
+}
+
+// the batches of the assignment originally hand-written in main.go
+var firstBatch = []string{
+	"7559412695850999704437639814226631134667359700514660715427262528648684612384",
+	"66128905217727820142075711671179697108908215459957692935244063164243782161424",
+	"51015742989614192140374653588448216776344032110315281841496138794886522140476",
+	"35122383026158949466484037373710698093278849499198161694631609784776227649041",
+	"26172153391189409300153675552195806917108259526780793769448239894068277983117",
+	"26319945699020872042776764262800211039811709625022690029869433243912894238514",
+	"85459787920741173308529179304076764583420917452546478748737995277072485407899",
+	"37824474589860659728395896987471423117349358731852046326799624553171445743149",
+	"61194402916979300094031158454825880129228850504669718400883285170758259346137",
+	"24246882173524206786121934947990875280633571158623508995012743986254503068477",
+	"93199772650225608593183888507906173669398210074847791089995208298919581733292",
+	"33909163889869673678628757617712328003205266681277740626071514924998877887543",
+	"57565043458143669655443451912855736172750697736668570413272031819358780748047",
+	"37243082771427767710089206757934373481061954243301630231967571484585860082658",
+	"16563559798946351326855328924389131968545894673507955726309781333266729822892",
+	"48994785319657803905781873709543292037955196759232529867686143523322370022071",
+}
+
+var secondBatch = []string{
+	"8156319925050744557782245694037100563564059020340687679749164066021286143836",
+	"95909223809388993694993492400467043881733915630342324449340732043292438402430",
+	"57414147262588752917658270334485249249923597373198409036320819119810373085930",
+	"113670449272529920882410221941222150904120358535747236216730630644724274198177",
+	"57398552932645399891307139678071663482334851558675487776494006109344731328249",
+	"103143881793116431352669765361473968236332048388061021423670947940287385762294",
+	"81612725631244049093044665038120176880687858193615788788599062677724186199322",
+	"24221479052158155394601047524206106254731735391378752996520107136176279845840",
+	"49556574245612851804963807434730031772247272089317479498002818782916042154755",
+	"56426423107537836944547904423637136789783854836853497598720872599439703238350",
+	"20422616371558051321328641762545775674999844341281317444259962231474038621913",
+	"70721761249807583889417427160262976417209826966551495121360338241351139517252",
+	"67154409164492473022545374284896465659184269211491885695865972146826122887517",
+	"14641621711817804362131083482888050433544024750770175084260876296122465116391",
+	"44449413951567356658450979332805732743618650826831158077837287657342508935054",
+	"49888894850490203642386712655024128420501187089752845687448626231471203382973",
+}
+
+func insertNoteHashes(t *testing.T, tr *tree.Tree, noteHashes [][32]byte) {
+	for _, h := range noteHashes {
+		if err := tr.Insert(tree.NoteLeaf(h)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildWitnessMatchesHandWrittenAssignment(t *testing.T) {
+	tr := tree.New()
+	insertNoteHashes(t, tr, noteHashesFromDecimal(t, firstBatch))
+	w, err := BuildWitness(tr, noteHashesFromDecimal(t, secondBatch))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := w.Assignment
+	for _, c := range []struct {
+		name string
+		got  frontend.Variable
+		want string
+	}{
+		// the accumulator hash of the hand-written assignment was over the raw note hashes,
+		// these are over their BCS encoding
+		{"AccumulatorHash", a.AccumulatorHash, "8385964967883884362316148090050726943446488258775256734484902440364612975856"},
+		{"EncodedPathAndHash", a.EncodedPathAndHash, "1879048193"},
+		// and the leaves are the low 253 bits of the note hashes instead of their value
+		// modulo r, which changes every root above them
+		{"OldRoot", a.OldRoot, "10935760037179253960860775392166325310979841057162858558753442307989693214285"},
+		{"NewRoot", a.NewRoot, "2808661071753066150005561444880901951258276788764713635951725900083784746850"},
+		{"Siblings[0][0]", a.Siblings[0][0], "7605789948970629995841535545896362187032086454871378777338702012762736641273"},
+		{"Siblings[0][1]", a.Siblings[0][1], "13867732332339151465497925642082178974038372652152621168903203076445231043372"},
+		{"Siblings[13][2]", a.Siblings[13][2], "20734118650853257426634229445255987190193218607444720047392808113569367837624"},
+	} {
+		if got := c.got.(*big.Int).String(); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+	if tr.Count() != tree.BatchSize {
+		t.Fatal("BuildWitness modified the tree")
+	}
+	if err := test.IsSolved(NewSubtreeUpdateCircuit(), a, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAccumulatorHashMatchesMove reproduces the accumulator hash of the batch inserted by
+// test_update_tree_and_verify_proof in libs/sources/tree_utils_test.move.
+func TestAccumulatorHashMatchesMove(t *testing.T) {
+	noteHashes := make([][32]byte, tree.BatchSize)
+	for i := range noteHashes {
+		// bcs::to_bytes of create_encoded_note(0x1, 0x1, i, 1)
+		note := bcs.AppendU256(nil, big.NewInt(1))
+		note = bcs.AppendU256(note, big.NewInt(1))
+		note = bcs.AppendU64(note, uint64(i))
+		note = bcs.AppendU64(note, 1)
+		noteHashes[i] = sha256.Sum256(note)
+	}
+	want := "35391017205645498333932229288428234435008798448262589111347252333824232751540"
+	if got := AccumulatorHash(noteHashes).String(); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	w, err := BuildWitness(tree.New(), noteHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := test.IsSolved(NewSubtreeUpdateCircuit(), w.Assignment, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuildWitnessIsSolved(t *testing.T) {
+	tr := tree.New()
+	// subtrees 4 and 5 take the path through the second digit
+	for i := uint64(0); i < 6; i++ {
+		batch := randomNoteHashes(i)
+		w, err := BuildWitness(tr, batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.SubtreeIndex != i || w.OldRoot.Cmp(tr.Root()) != 0 {
+			t.Fatalf("batch %d: witness for subtree %d", i, w.SubtreeIndex)
+		}
+		a := w.Assignment
+		if err := test.IsSolved(NewSubtreeUpdateCircuit(), a, ecc.BN254.ScalarField()); err != nil {
+			t.Fatalf("batch %d: %v", i, err)
+		}
+		insertNoteHashes(t, tr, batch)
+		if w.NewRoot.Cmp(tr.Root()) != 0 {
+			t.Fatalf("batch %d: new root %s, tree root %s", i, w.NewRoot, tr.Root())
+		}
+		want, err := PublicInputs(w.OldRoot, w.NewRoot, w.SubtreeIndex, w.AccumulatorHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertPublicInputs(t, w.Public, want)
+	}
+}
+
+func assertPublicInputs(t *testing.T, public witness.Witness, want []*big.Int) {
+	t.Helper()
+	pub := public.Vector().(fr.Vector)
+	if len(pub) != len(want) {
+		t.Fatalf("%d public inputs, want %d", len(pub), len(want))
+	}
+	for j := range want {
+		if pub[j].BigInt(new(big.Int)).Cmp(want[j]) != 0 {
+			t.Fatalf("public input %d is %s, want %s", j, pub[j].String(), want[j])
+		}
+	}
+}
+
+// TestPublicInputsOrder pins the public witness to the order of calculate_public_inputs,
+// [root, new_root, encoded_path_and_hash, lo], whatever the field order of the circuit.
+func TestPublicInputsOrder(t *testing.T) {
+	tr := tree.New()
+	insertNoteHashes(t, tr, randomNoteHashes(0))
+	w, err := BuildWitness(tr, randomNoteHashes(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hi := new(big.Int).Rsh(w.AccumulatorHash, 253)
+	lo := new(big.Int).And(w.AccumulatorHash, new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 253), big.NewInt(1)))
+	// subtree 1, with the top bits of the hash from bit 28
+	encoded := new(big.Int).Or(big.NewInt(1), new(big.Int).Lsh(hi, 28))
+	want := []*big.Int{w.OldRoot, w.NewRoot, encoded, lo}
+
+	got, err := PublicInputs(w.OldRoot, w.NewRoot, 1, w.AccumulatorHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		if got[i].Cmp(want[i]) != 0 {
+			t.Fatalf("public input %d is %s, want %s", i, got[i], want[i])
+		}
+	}
+	assertPublicInputs(t, w.Public, want)
+	public, err := PublicWitness(w.OldRoot, w.NewRoot, 1, w.AccumulatorHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPublicInputs(t, public, want)
+
+	// the names of the public inputs, in order
+	s, err := frontend.NewSchema(NewSubtreeUpdateCircuit())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range s.Fields[:s.NbPublic] {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "OldRoot,NewRoot,EncodedPathAndHash,AccumulatorHash" {
+		t.Fatalf("public inputs %s", got)
+	}
+}
+
+func TestBuildWitnessErrors(t *testing.T) {
+	tr := tree.New()
+	if _, err := BuildWitness(tr, randomNoteHashes(0)[:15]); err != tree.ErrBatchSize {
+		t.Fatalf("got %v, want %v", err, tree.ErrBatchSize)
+	}
+	if err := tr.Insert(big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BuildWitness(tr, randomNoteHashes(0)); err != tree.ErrBatchAligned {
+		t.Fatalf("got %v, want %v", err, tree.ErrBatchAligned)
+	}
+}
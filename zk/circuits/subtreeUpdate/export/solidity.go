@@ -0,0 +1,59 @@
+package export
+
+import (
+	"fmt"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"golang.org/x/crypto/sha3"
+	"io"
+)
+
+// SolidityVerifier writes a Solidity contract verifying the proofs of vk. Its verifyProof
+// function takes the public inputs in the order of the public witness.
+func SolidityVerifier(w io.Writer, vk groth16.VerifyingKey) error {
+	return vk.ExportSolidity(w)
+}
+
+// keccak256 is the legacy Keccak-256 of Ethereum, which pads with 0x01 instead of the
+// 0x06 of SHA3-256. It is only used for ABI selectors.
+func keccak256(data []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// verifyProofSelector returns the selector of the verifyProof function of the contract
+// written by SolidityVerifier for nbInputs public inputs.
+func verifyProofSelector(nbInputs int) []byte {
+	signature := fmt.Sprintf("verifyProof(uint256[2],uint256[2][2],uint256[2],uint256[%d])", nbInputs)
+	return keccak256([]byte(signature))[:4]
+}
+
+// SolidityCalldata returns the ABI encoded call of verifyProof for a proof and its public
+// witness. G2 coordinates are written as (A1, A0), the order of the pairing precompile.
+func SolidityCalldata(p groth16.Proof, public witness.Witness) ([]byte, error) {
+	pr, err := decodeProof(p)
+	if err != nil {
+		return nil, err
+	}
+	inputs, ok := public.Vector().(fr.Vector)
+	if !ok {
+		return nil, ErrNotBN254
+	}
+	b := verifyProofSelector(len(inputs))
+	for _, x := range []*fp.Element{
+		&pr.Ar.X, &pr.Ar.Y,
+		&pr.Bs.X.A1, &pr.Bs.X.A0, &pr.Bs.Y.A1, &pr.Bs.Y.A0,
+		&pr.Krs.X, &pr.Krs.Y,
+	} {
+		w := x.Bytes()
+		b = append(b, w[:]...)
+	}
+	for i := range inputs {
+		w := inputs[i].Bytes()
+		b = append(b, w[:]...)
+	}
+	return b, nil
+}
@@ -0,0 +1,167 @@
+package export
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"math/big"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestVerifyProofSelector(t *testing.T) {
+	if got := hex.EncodeToString(keccak256([]byte("transfer(address,uint256)"))[:4]); got != "a9059cbb" {
+		t.Errorf("transfer selector %s, want a9059cbb", got)
+	}
+	if got := hex.EncodeToString(verifyProofSelector(4)); got != "5fe8c13b" {
+		t.Errorf("verifyProof selector %s, want 5fe8c13b", got)
+	}
+}
+
+// contract is the verifying key hard-coded in a contract written by SolidityVerifier.
+type contract struct {
+	alfa1                 bn254.G1Affine
+	beta2, gamma2, delta2 bn254.G2Affine
+	k                     []bn254.G1Affine
+}
+
+var (
+	uint256Re = `uint256\((\d+)\)`
+	g1Re      = regexp.MustCompile(`vk\.(alfa1) = Pairing\.G1Point\(` + uint256Re + `, ` + uint256Re + `\);`)
+	g2Re      = regexp.MustCompile(`vk\.(\w+2) = Pairing\.G2Point\(\[` + uint256Re + `, ` + uint256Re + `\], \[` + uint256Re + `, ` + uint256Re + `\]\);`)
+	kRe       = regexp.MustCompile(`= ` + uint256Re + `; // vk\.K\[(\d+)\]\.([XY])`)
+)
+
+// parseContract reads the verifying key back from the Solidity source.
+func parseContract(t *testing.T, src string) *contract {
+	var c contract
+	m := g1Re.FindStringSubmatch(src)
+	if m == nil {
+		t.Fatal("alfa1 not found")
+	}
+	c.alfa1.X.SetString(m[2])
+	c.alfa1.Y.SetString(m[3])
+	g2 := map[string]*bn254.G2Affine{"beta2": &c.beta2, "gamma2": &c.gamma2, "delta2": &c.delta2}
+	for _, m := range g2Re.FindAllStringSubmatch(src, -1) {
+		p := g2[m[1]]
+		// the contract holds [X.A1, X.A0], [Y.A1, Y.A0]
+		p.X.A1.SetString(m[2])
+		p.X.A0.SetString(m[3])
+		p.Y.A1.SetString(m[4])
+		p.Y.A0.SetString(m[5])
+		delete(g2, m[1])
+	}
+	if len(g2) != 0 {
+		t.Fatalf("G2 points not found: %v", g2)
+	}
+	k := map[string]*bn254.G1Affine{}
+	for _, m := range kRe.FindAllStringSubmatch(src, -1) {
+		if k[m[2]] == nil {
+			k[m[2]] = new(bn254.G1Affine)
+		}
+		if m[3] == "X" {
+			k[m[2]].X.SetString(m[1])
+		} else {
+			k[m[2]].Y.SetString(m[1])
+		}
+	}
+	for i := 0; k[strconv.Itoa(i)] != nil; i++ {
+		c.k = append(c.k, *k[strconv.Itoa(i)])
+	}
+	if len(c.k) != len(k) {
+		t.Fatalf("public input bases are not numbered from 0: %d of %d", len(c.k), len(k))
+	}
+	return &c
+}
+
+// verifyProof evaluates the verifyProof function of c on ABI encoded calldata.
+func (c *contract) verifyProof(t *testing.T, calldata []byte) bool {
+	nbInputs := len(c.k) - 1
+	if !bytes.Equal(calldata[:4], verifyProofSelector(nbInputs)) || len(calldata) != 4+32*(8+nbInputs) {
+		t.Fatalf("calldata is not a call of verifyProof with %d inputs", nbInputs)
+	}
+	words := make([]*big.Int, (len(calldata)-4)/32)
+	for i := range words {
+		words[i] = new(big.Int).SetBytes(calldata[4+32*i : 4+32*(i+1)])
+	}
+	var a, cc bn254.G1Affine
+	var b bn254.G2Affine
+	a.X.SetBigInt(words[0])
+	a.Y.SetBigInt(words[1])
+	b.X.A1.SetBigInt(words[2])
+	b.X.A0.SetBigInt(words[3])
+	b.Y.A1.SetBigInt(words[4])
+	b.Y.A0.SetBigInt(words[5])
+	cc.X.SetBigInt(words[6])
+	cc.Y.SetBigInt(words[7])
+
+	vkX := c.k[0]
+	for i, in := range words[8:] {
+		if in.Cmp(fr.Modulus()) >= 0 {
+			return false
+		}
+		var term bn254.G1Affine
+		term.ScalarMultiplication(&c.k[i+1], in)
+		vkX.Add(&vkX, &term)
+	}
+	var negA bn254.G1Affine
+	negA.Neg(&a)
+	ok, err := bn254.PairingCheck(
+		[]bn254.G1Affine{negA, c.alfa1, vkX, cc},
+		[]bn254.G2Affine{b, c.beta2, c.gamma2, c.delta2},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestSolidityCalldataMatchesGnark(t *testing.T) {
+	vk, proof, public := fixtures(t)
+	var src bytes.Buffer
+	if err := SolidityVerifier(&src, vk); err != nil {
+		t.Fatal(err)
+	}
+	c := parseContract(t, src.String())
+	if len(c.k) != 5 {
+		t.Fatalf("contract has %d public input bases, want 5", len(c.k))
+	}
+	calldata, err := SolidityCalldata(proof, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.verifyProof(t, calldata) {
+		t.Fatal("contract rejects the calldata of a proof accepted by gnark")
+	}
+
+	// a modified public input is rejected by both
+	v := public.Vector().(fr.Vector)
+	tampered := make(fr.Vector, len(v))
+	copy(tampered, v)
+	tampered[2].SetUint64(1)
+	tamperedPublic, err := witness.New(fr.Modulus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(chan any, len(tampered))
+	for _, x := range tampered {
+		values <- x
+	}
+	close(values)
+	if err := tamperedPublic.Fill(len(tampered), 0, values); err != nil {
+		t.Fatal(err)
+	}
+	if groth16.Verify(proof, vk, tamperedPublic) == nil {
+		t.Fatal("gnark accepts a modified public input")
+	}
+	if calldata, err = SolidityCalldata(proof, tamperedPublic); err != nil {
+		t.Fatal(err)
+	}
+	if c.verifyProof(t, calldata) {
+		t.Fatal("contract accepts a modified public input")
+	}
+}
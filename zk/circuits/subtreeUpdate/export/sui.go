@@ -0,0 +1,165 @@
+// Package export converts groth16 keys, proofs and public inputs to the formats of the
+// chains that verify subtree updates.
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"io"
+)
+
+// Flags of arkworks compressed points, in the most significant bits of their last byte.
+const (
+	// arkYLargest is set when y > -y, gnark's lexicographically largest y.
+	arkYLargest = 0x80
+	arkInfinity = 0x40
+)
+
+var ErrNotBN254 = errors.New("export: not a BN254 key or proof")
+
+// verifyingKey holds the points of a gnark groth16 verifying key, whose concrete type is
+// internal to gnark.
+type verifyingKey struct {
+	Alpha              bn254.G1Affine
+	Beta, Gamma, Delta bn254.G2Affine
+	// K are the public input bases, K[0] the one of the constant 1.
+	K []bn254.G1Affine
+}
+
+type proof struct {
+	Ar, Krs bn254.G1Affine
+	Bs      bn254.G2Affine
+}
+
+// decode reads the points written by WriteRawTo.
+func decode(v interface {
+	CurveID() ecc.ID
+	WriteRawTo(io.Writer) (int64, error)
+}, points ...interface{}) error {
+	if v.CurveID() != ecc.BN254 {
+		return ErrNotBN254
+	}
+	var buf bytes.Buffer
+	if _, err := v.WriteRawTo(&buf); err != nil {
+		return err
+	}
+	dec := bn254.NewDecoder(&buf)
+	for _, p := range points {
+		if err := dec.Decode(p); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+	}
+	return nil
+}
+
+func decodeVerifyingKey(vk groth16.VerifyingKey) (*verifyingKey, error) {
+	var res verifyingKey
+	// gnark also writes [β]1 and [δ]1, that arkworks keys do not have
+	var betaG1, deltaG1 bn254.G1Affine
+	if err := decode(vk, &res.Alpha, &betaG1, &res.Beta, &res.Gamma, &deltaG1, &res.Delta, &res.K); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func decodeProof(p groth16.Proof) (*proof, error) {
+	var res proof
+	if err := decode(p, &res.Ar, &res.Bs, &res.Krs); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SuiVerifyingKey encodes vk for sui::groth16::prepare_verifying_key with the bn254 curve:
+// the arkworks compressed VerifyingKey, alpha_g1, beta_g2, gamma_g2, delta_g2, then the
+// u64 little-endian length and the points of gamma_abc_g1.
+func SuiVerifyingKey(vk groth16.VerifyingKey) ([]byte, error) {
+	k, err := decodeVerifyingKey(vk)
+	if err != nil {
+		return nil, err
+	}
+	b := appendG1(nil, &k.Alpha)
+	b = appendG2(b, &k.Beta)
+	b = appendG2(b, &k.Gamma)
+	b = appendG2(b, &k.Delta)
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(k.K)))
+	for i := range k.K {
+		b = appendG1(b, &k.K[i])
+	}
+	return b, nil
+}
+
+// SuiProof encodes p for sui::groth16::proof_points_from_bytes: the arkworks compressed
+// Proof, a, b and c.
+func SuiProof(p groth16.Proof) ([]byte, error) {
+	pr, err := decodeProof(p)
+	if err != nil {
+		return nil, err
+	}
+	b := appendG1(nil, &pr.Ar)
+	b = appendG2(b, &pr.Bs)
+	return appendG1(b, &pr.Krs), nil
+}
+
+// SuiPublicInputs encodes a public witness for sui::groth16::public_proof_inputs_from_bytes:
+// every input as 32 little-endian bytes.
+func SuiPublicInputs(public witness.Witness) ([]byte, error) {
+	v, ok := public.Vector().(fr.Vector)
+	if !ok {
+		return nil, ErrNotBN254
+	}
+	var b []byte
+	for i := range v {
+		be := v[i].Bytes()
+		for j := len(be) - 1; j >= 0; j-- {
+			b = append(b, be[j])
+		}
+	}
+	return b, nil
+}
+
+// appendFp appends x as 32 little-endian bytes.
+func appendFp(b []byte, x *fp.Element) []byte {
+	be := x.Bytes()
+	for i := len(be) - 1; i >= 0; i-- {
+		b = append(b, be[i])
+	}
+	return b
+}
+
+// appendG1 appends p in arkworks' compressed encoding: x little-endian, flags in the top
+// bits of the last byte.
+func appendG1(b []byte, p *bn254.G1Affine) []byte {
+	if p.IsInfinity() {
+		b = append(b, make([]byte, 31)...)
+		return append(b, arkInfinity)
+	}
+	b = appendFp(b, &p.X)
+	if p.Y.LexicographicallyLargest() {
+		b[len(b)-1] |= arkYLargest
+	}
+	return b
+}
+
+// appendG2 appends p in arkworks' compressed encoding: x.A0 then x.A1, little-endian,
+// flags in the top bits of the last byte.
+func appendG2(b []byte, p *bn254.G2Affine) []byte {
+	if p.IsInfinity() {
+		b = append(b, make([]byte, 63)...)
+		return append(b, arkInfinity)
+	}
+	b = appendFp(b, &p.X.A0)
+	b = appendFp(b, &p.X.A1)
+	if p.Y.LexicographicallyLargest() {
+		b[len(b)-1] |= arkYLargest
+	}
+	return b
+}
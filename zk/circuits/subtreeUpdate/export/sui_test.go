@@ -0,0 +1,153 @@
+package export
+
+import (
+	"encoding/hex"
+	"flag"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// fixtures are a verifying key, proof and public witness of SubtreeUpdateCircuit, in gnark's
+// encoding, from a local setup
+func fixtures(t *testing.T) (groth16.VerifyingKey, groth16.Proof, witness.Witness) {
+	vk := groth16.NewVerifyingKey(ecc.BN254)
+	proof := groth16.NewProof(ecc.BN254)
+	for name, v := range map[string]interface {
+		ReadFrom(r io.Reader) (int64, error)
+	}{"subtreeUpdate.vk": vk, "proof": proof} {
+		f, err := os.Open(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = v.ReadFrom(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", "public.wtns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := public.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := groth16.Verify(proof, vk, public); err != nil {
+		t.Fatal(err)
+	}
+	return vk, proof, public
+}
+
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(got)+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := hex.EncodeToString(got); g != strings.TrimSpace(string(want)) {
+		t.Fatalf("%s: got %s, want %s", name, g, want)
+	}
+}
+
+func TestSuiGolden(t *testing.T) {
+	vk, proof, public := fixtures(t)
+	b, err := SuiVerifyingKey(vk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// alpha, 3 G2 points, and the bases of the constant and the 4 public inputs
+	if len(b) != 32+3*64+8+5*32 {
+		t.Fatalf("verifying key is %d bytes", len(b))
+	}
+	golden(t, "subtreeUpdate.vk.sui", b)
+
+	if b, err = SuiProof(proof); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "proof.sui", b)
+
+	if b, err = SuiPublicInputs(public); err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "public.sui", b)
+}
+
+// fromArkworksG1 decodes an arkworks compressed point through gnark's compressed
+// encoding, which is big-endian with flags in the first byte.
+func fromArkworksG1(t *testing.T, b []byte) bn254.G1Affine {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[i] = b[len(b)-1-i]
+	}
+	flags := be[0] & 0xc0
+	be[0] &^= 0xc0
+	switch flags {
+	case arkInfinity:
+		be[0] |= 0b01 << 6
+	case arkYLargest:
+		be[0] |= 0b11 << 6
+	case 0:
+		be[0] |= 0b10 << 6
+	}
+	var p bn254.G1Affine
+	if _, err := p.SetBytes(be); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestArkworksPoints(t *testing.T) {
+	_, _, g1, g2 := bn254.Generators()
+	var negG1 bn254.G1Affine
+	negG1.Neg(&g1)
+	var negG2 bn254.G2Affine
+	negG2.Neg(&g2)
+	for _, c := range []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		// the generators are (1, 2) and (1, -2)
+		{"g1", appendG1(nil, &g1), "0100000000000000000000000000000000000000000000000000000000000000"},
+		{"-g1", appendG1(nil, &negG1), "0100000000000000000000000000000000000000000000000000000000000080"},
+		{"g1 infinity", appendG1(nil, &bn254.G1Affine{}), "0000000000000000000000000000000000000000000000000000000000000040"},
+		{"g2", appendG2(nil, &g2), "edf692d95cbdde46ddda5ef7d422436779445c5e66006a42761e1f12efde0018c212f3aeb785e49712e7a9353349aaf1255dfb31b7bf60723a480d9293938e19"},
+		{"-g2", appendG2(nil, &negG2), "edf692d95cbdde46ddda5ef7d422436779445c5e66006a42761e1f12efde0018c212f3aeb785e49712e7a9353349aaf1255dfb31b7bf60723a480d9293938e99"},
+		{"g2 infinity", appendG2(nil, &bn254.G2Affine{}), strings.Repeat("00", 63) + "40"},
+	} {
+		if got := hex.EncodeToString(c.got); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+
+	// the key points survive the round trip through the arkworks encoding
+	vk, _, _ := fixtures(t)
+	k, err := decodeVerifyingKey(vk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range append([]bn254.G1Affine{k.Alpha}, k.K...) {
+		if got := fromArkworksG1(t, appendG1(nil, &p)); !got.Equal(&p) {
+			t.Fatalf("point %d: got %s, want %s", i, got.String(), p.String())
+		}
+	}
+}
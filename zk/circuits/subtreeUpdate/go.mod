@@ -5,6 +5,7 @@ go 1.20
 require (
 	github.com/consensys/gnark v0.8.0
 	github.com/consensys/gnark-crypto v0.9.1
+	golang.org/x/crypto v0.8.0
 )
 
 require (
@@ -22,7 +23,7 @@ require (
 	github.com/rs/zerolog v1.29.0 // indirect
 	github.com/stretchr/testify v1.8.1 // indirect
 	github.com/x448/float16 v0.8.4 // indirect
-	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/sys v0.7.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 	rsc.io/tmplfunc v0.0.3 // indirect
 )
@@ -1,569 +1,620 @@
+// Command subtreeUpdate compiles the subtree update circuit, sets it up, and proves and
+// verifies subtree updates, one by one or as the updater service.
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	"github.com/consensys/gnark/backend/groth16"
-	"github.com/consensys/gnark/frontend"
-	"github.com/consensys/gnark/frontend/cs/r1cs"
-	"github.com/consensys/gnark/std/math/bits"
+	"github.com/consensys/gnark/backend/witness"
+	"io"
 	"math/big"
-	"subtreeUpdate/merkle"
-	"subtreeUpdate/poseidon"
-	sha2_256 "subtreeUpdate/sha256"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"subtreeUpdate/circuit"
+	"subtreeUpdate/export"
+	"subtreeUpdate/prover"
+	"subtreeUpdate/tree"
+	"subtreeUpdate/updater"
+	"syscall"
+	"time"
 )
 
-type subtreeUpdateCircuit struct {
-	AccumulatorHash             frontend.Variable     `gnark:"accumulatorHash,public"`
-	EncodedPathAndHash          frontend.Variable     `gnark:"encodedPathAndHash,public"`
-	OldRoot                     frontend.Variable     `gnark:"oldRoot,public"`
-	NewRoot                     frontend.Variable     `gnark:"newRoot,public"`
-	SubtreeMembershipProof      merkle.MerkleProof    `gnark:"subtreeMembershipProof,private"`
-	EmptySubtreeMembershipProof merkle.MerkleProof    `gnark:"emptySubtreeMembershipProof,private"`
-	Preimage                    []frontend.Variable   `gnark:"preImage"`
-	Leaves                      [16]frontend.Variable `gnark:"leaves,private"`
+// defaultDir is the default key directory, see prover.Save.
+const defaultDir = "keys"
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
 }
 
-func (circuit *subtreeUpdateCircuit) Define(api frontend.API) error {
-	h := poseidon.NewPoseidonHash(api)
-	api.AssertIsEqual(circuit.SubtreeMembershipProof.Leaf, merkle.ComputeRootFromLeaves(api, h, circuit.Leaves))
-	emptyTreeLeaves := [16]frontend.Variable{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
-	api.AssertIsEqual(circuit.EmptySubtreeMembershipProof.Leaf, merkle.ComputeRootFromLeaves(api, h, emptyTreeLeaves))
+var commands = []command{
+	{"compile", "compile the circuit and write its R1CS to the key directory", compileCmd},
+	{"setup", "run or import a groth16 setup, unless the key directory is up to date", setupCmd},
+	{"witness", "build the witness of the next batch from a JSON file of note hashes", witnessCmd},
+	{"prove", "prove a JSON witness", proveCmd},
+	{"verify", "verify a proof against a JSON witness", verifyCmd},
+	{"inputs", "print the public inputs of a JSON witness", inputsCmd},
+	{"export", "export the verifying key, proofs and public inputs for Solidity or Sui", exportCmd},
+	{"update", "prove and submit the queued batches of a chain relay until interrupted", updateCmd},
+}
 
-	EncodedPathAndHashBits := bits.ToBinary(api, circuit.EncodedPathAndHash, bits.WithNbDigits(31))
-	hi := api.Add(EncodedPathAndHashBits[28], api.Mul(EncodedPathAndHashBits[29], frontend.Variable("2")), api.Mul(EncodedPathAndHashBits[30], frontend.Variable("4")))
-	path := api.Sub(circuit.EncodedPathAndHash, api.Mul(hi, frontend.Variable("268435456")))
-	api.AssertIsEqual(path, circuit.SubtreeMembershipProof.ComputePath(api))
-	api.AssertIsEqual(path, circuit.EmptySubtreeMembershipProof.ComputePath(api))
+var errUsage = errors.New("invalid arguments")
 
-	accumulatorHashBits := append(bits.ToBinary(api, circuit.AccumulatorHash, bits.WithNbDigits(253)), EncodedPathAndHashBits[28], EncodedPathAndHashBits[29], EncodedPathAndHashBits[30])
-	accumulatorHashBytes := make([]frontend.Variable, 32)
-	for i := 0; i < 32; i++ {
-		accumulatorHashBytes[i] = api.Add(
-			api.Mul(accumulatorHashBits[255-8*i], frontend.Variable("128")),
-			api.Mul(accumulatorHashBits[254-8*i], frontend.Variable("64")),
-			api.Mul(accumulatorHashBits[253-8*i], frontend.Variable("32")),
-			api.Mul(accumulatorHashBits[252-8*i], frontend.Variable("16")),
-			api.Mul(accumulatorHashBits[251-8*i], frontend.Variable("8")),
-			api.Mul(accumulatorHashBits[250-8*i], frontend.Variable("4")),
-			api.Mul(accumulatorHashBits[249-8*i], frontend.Variable("2")),
-			accumulatorHashBits[248-8*i],
-		)
-	}
-	sha256 := sha2_256.New(api)
-	sha256.Reset()
-	sha256.Write(circuit.Preimage[:])
-	result := sha256.Sum()
-	for i := range result {
-		api.AssertIsEqual(result[i], accumulatorHashBytes[i])
-	}
-	circuit.SubtreeMembershipProof.VerifyProof(api, h)
-	circuit.EmptySubtreeMembershipProof.VerifyProof(api, h)
-	api.AssertIsEqual(circuit.OldRoot, circuit.EmptySubtreeMembershipProof.RootHash)
-	api.AssertIsEqual(circuit.NewRoot, circuit.SubtreeMembershipProof.RootHash)
-	return nil
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.usage)
+	}
 }
 
 func main() {
-	// compiles our circuit into a R1CS
-	circuit := subtreeUpdateCircuit{
-		Preimage: make([]frontend.Variable, 512),
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name != os.Args[1] {
+			continue
+		}
+		if err := c.run(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", c.name, err)
+			if errors.Is(err, errUsage) {
+				os.Exit(2)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+	usage()
+	os.Exit(2)
+}
+
+// parse parses args, and fails if any of the required flags is empty.
+func parse(fs *flag.FlagSet, args []string, required ...string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("%w: unexpected argument %q", errUsage, fs.Arg(0))
+	}
+	for _, name := range required {
+		if fs.Lookup(name).Value.String() == "" {
+			return fmt.Errorf("%w: -%s is required", errUsage, name)
+		}
+	}
+	return nil
+}
+
+// circuitFlags select the circuit of a command: the configuration of its batches, see
+// circuit.Config, and how many it inserts at once.
+type circuitFlags struct {
+	config      circuit.Config
+	accumulator string
+	nbBatches   int
+}
+
+func addCircuitFlags(fs *flag.FlagSet) *circuitFlags {
+	f := &circuitFlags{config: circuit.DefaultConfig}
+	fs.IntVar(&f.config.Depth, "depth", f.config.Depth, "depth of the tree, DEPTH of the contract")
+	fs.IntVar(&f.config.BatchSize, "batch", f.config.BatchSize, "notes per batch, BATCH_SIZE of the contract")
+	fs.BoolVar(&f.config.Partial, "partial", false, "insert batches of 1 to -batch notes, padded with zero leaves")
+	fs.StringVar(&f.accumulator, "accumulator", circuit.SHA256.String(), "accumulator hash of a batch, sha256 or poseidon")
+	fs.IntVar(&f.nbBatches, "batches", 1, "number of batches inserted at once")
+	return f
+}
+
+// check completes the configuration once the flags are parsed.
+func (f *circuitFlags) check() error {
+	switch f.accumulator {
+	case circuit.SHA256.String():
+		f.config.Accumulator = circuit.SHA256
+	case circuit.Poseidon.String():
+		f.config.Accumulator = circuit.Poseidon
+	default:
+		return fmt.Errorf("%w: unknown accumulator %q", errUsage, f.accumulator)
+	}
+	if err := f.config.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if f.nbBatches < 1 {
+		return fmt.Errorf("%w: %d batches", errUsage, f.nbBatches)
+	}
+	return nil
+}
+
+// verifyingKey reads the verifying key at path, the one of f in dir if empty, and checks
+// that it belongs to the circuit of f.
+func (f *circuitFlags) verifyingKey(dir, path string) (groth16.VerifyingKey, error) {
+	if path == "" {
+		path = filepath.Join(dir, prover.FileName(prover.VerifyingKeyFile, f.nbBatches))
+	}
+	h, err := prover.Hash(f.config, f.nbBatches)
+	if err != nil {
+		return nil, err
+	}
+	vk, got, err := prover.ReadVerifyingKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if got != h {
+		return nil, fmt.Errorf("%w: %s is for circuit %s, not %s", prover.ErrCircuitChanged, path, got, h)
+	}
+	return vk, nil
+}
+
+func (f *circuitFlags) readWitness(path string) (witness.Witness, error) {
+	return prover.ReadWitness(f.config, f.nbBatches, path)
+}
+
+func compileCmd(args []string) error {
+	fs := flag.NewFlagSet("compile", flag.ContinueOnError)
+	dir := fs.String("dir", defaultDir, "key directory")
+	table := fs.Bool("table", false, "only print the number of constraints of every configuration")
+	force := fs.Bool("force", false, "replace the R1CS of another circuit, which is refused otherwise")
+	f := addCircuitFlags(fs)
+	if err := parse(fs, args, "dir"); err != nil {
+		return err
+	}
+	if *table {
+		return printConstraintTable()
+	}
+	if err := f.check(); err != nil {
+		return err
+	}
+	h, err := prover.Hash(f.config, f.nbBatches)
+	if err != nil {
+		return err
+	}
+	ccs, err := prover.CompileBatches(f.config, f.nbBatches)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("circuit %s: %d constraints\n", h, ccs.GetNbConstraints())
+	return forceHint(prover.Save(*dir, &prover.Keys{Hash: h, NbBatches: f.nbBatches, CCS: ccs}, *force))
+}
+
+// printConstraintTable compiles every configuration of circuit.Configs, and the
+// multi-batch circuits of circuit.DefaultConfig.
+func printConstraintTable() error {
+	fmt.Printf("%-6s %-6s %s\n", "depth", "batch", "constraints")
+	for _, c := range circuit.Configs {
+		ccs, err := prover.CompileConfig(c)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-6d %-6d %d", c.Depth, c.BatchSize, ccs.GetNbConstraints())
+		if c.Partial {
+			fmt.Print(" partial")
+		}
+		if c.Accumulator != circuit.SHA256 {
+			fmt.Printf(" %s", c.Accumulator)
+		}
+		fmt.Println()
+	}
+	for _, n := range []int{2, 4} {
+		ccs, err := prover.CompileMulti(circuit.DefaultConfig, n)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-6d %-6d %d %d batches\n", circuit.DefaultConfig.Depth, circuit.DefaultConfig.BatchSize, ccs.GetNbConstraints(), n)
+	}
+	return nil
+}
+
+// forceHint suggests -force when a key directory holds the files of another circuit.
+func forceHint(err error) error {
+	if errors.Is(err, prover.ErrCircuitChanged) {
+		return fmt.Errorf("%w, set -force to replace its files", err)
 	}
-	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+	return err
+}
+
+// loadKeys loads the keys of the circuit inserting nbBatches batches of configuration c from
+// dir. If check is set, it compiles the circuit and refuses keys of other constraints.
+func loadKeys(dir string, c circuit.Config, nbBatches int, check bool) (*prover.Keys, error) {
+	k, err := prover.Load(dir, c, nbBatches)
+	if err != nil || !check {
+		return k, err
+	}
+	ccs, err := prover.CompileBatches(c, nbBatches)
 	if err != nil {
-		fmt.Println("circuit compile error :", err)
-	}
-	// groth16 zkSNARK: Setup
-	pk, vk, err1 := groth16.Setup(ccs)
-	if err1 != nil {
-		fmt.Println("groth16 setup error :", err1)
-	}
-	assignment := subtreeUpdateCircuit{
-		AccumulatorHash:    "4227817616696660701143006310345000348277930956434317194440135539501115863057",
-		EncodedPathAndHash: "1610612737",
-		OldRoot:            "14751455653696551972598626902324480412263087291693189381022091614544374105930",
-		NewRoot:            "20124835335623687480810663312320583159298054440202737370297960754448161307188",
-		SubtreeMembershipProof: merkle.MerkleProof{
-			RootHash: "20124835335623687480810663312320583159298054440202737370297960754448161307188",
-			Leaf:     "10311198283923373016267585188573545952089761138791529851438417096351462635353",
-			PathIndices: [14][2]frontend.Variable{
-				{0, 1},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-			},
-			Siblings: [14][3]frontend.Variable{
-				{
-					"19308544306448469788048112111319950102602262858931723855938361542409857985469",
-					"13867732332339151465497925642082178974038372652152621168903203076445231043372",
-					"13867732332339151465497925642082178974038372652152621168903203076445231043372",
-				},
-				{
-					"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-					"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-					"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-				},
-				{
-					"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-					"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-					"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-				},
-				{
-					"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-					"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-					"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-				},
-				{
-					"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-					"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-					"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-				},
-				{
-					"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-					"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-					"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-				},
-				{
-					"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-					"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-					"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-				},
-				{
-					"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-					"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-					"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-				},
-				{
-					"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-					"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-					"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-				},
-				{
-					"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-					"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-					"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-				},
-				{
-					"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-					"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-					"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-				},
-				{
-					"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-					"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-					"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-				},
-				{
-					"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-					"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-					"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-				},
-				{
-					"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-					"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-					"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-				},
-			},
-		},
-		EmptySubtreeMembershipProof: merkle.MerkleProof{
-			RootHash: "14751455653696551972598626902324480412263087291693189381022091614544374105930",
-			Leaf:     "13867732332339151465497925642082178974038372652152621168903203076445231043372",
-			PathIndices: [14][2]frontend.Variable{
-				{0, 1},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-				{0, 0},
-			},
-			Siblings: [14][3]frontend.Variable{
-				{
-					"19308544306448469788048112111319950102602262858931723855938361542409857985469",
-					"13867732332339151465497925642082178974038372652152621168903203076445231043372",
-					"13867732332339151465497925642082178974038372652152621168903203076445231043372",
-				},
-				{
-					"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-					"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-					"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-				},
-				{
-					"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-					"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-					"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-				},
-				{
-					"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-					"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-					"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-				},
-				{
-					"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-					"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-					"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-				},
-				{
-					"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-					"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-					"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-				},
-				{
-					"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-					"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-					"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-				},
-				{
-					"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-					"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-					"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-				},
-				{
-					"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-					"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-					"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-				},
-				{
-					"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-					"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-					"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-				},
-				{
-					"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-					"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-					"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-				},
-				{
-					"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-					"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-					"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-				},
-				{
-					"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-					"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-					"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-				},
-				{
-					"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-					"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-					"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-				},
-			},
-		},
-		Preimage: make([]frontend.Variable, 512),
-		Leaves: [16]frontend.Variable{
-			"8156319925050744557782245694037100563564059020340687679749164066021286143836",
-			"95909223809388993694993492400467043881733915630342324449340732043292438402430",
-			"57414147262588752917658270334485249249923597373198409036320819119810373085930",
-			"113670449272529920882410221941222150904120358535747236216730630644724274198177",
-			"57398552932645399891307139678071663482334851558675487776494006109344731328249",
-			"103143881793116431352669765361473968236332048388061021423670947940287385762294",
-			"81612725631244049093044665038120176880687858193615788788599062677724186199322",
-			"24221479052158155394601047524206106254731735391378752996520107136176279845840",
-			"49556574245612851804963807434730031772247272089317479498002818782916042154755",
-			"56426423107537836944547904423637136789783854836853497598720872599439703238350",
-			"20422616371558051321328641762545775674999844341281317444259962231474038621913",
-			"70721761249807583889417427160262976417209826966551495121360338241351139517252",
-			"67154409164492473022545374284896465659184269211491885695865972146826122887517",
-			"14641621711817804362131083482888050433544024750770175084260876296122465116391",
-			"44449413951567356658450979332805732743618650826831158077837287657342508935054",
-			"49888894850490203642386712655024128420501187089752845687448626231471203382973",
-		},
-	}
-	bytes := make([]byte, 512)
-	pre := [16]string{
-		"8156319925050744557782245694037100563564059020340687679749164066021286143836",
-		"95909223809388993694993492400467043881733915630342324449340732043292438402430",
-		"57414147262588752917658270334485249249923597373198409036320819119810373085930",
-		"113670449272529920882410221941222150904120358535747236216730630644724274198177",
-		"57398552932645399891307139678071663482334851558675487776494006109344731328249",
-		"103143881793116431352669765361473968236332048388061021423670947940287385762294",
-		"81612725631244049093044665038120176880687858193615788788599062677724186199322",
-		"24221479052158155394601047524206106254731735391378752996520107136176279845840",
-		"49556574245612851804963807434730031772247272089317479498002818782916042154755",
-		"56426423107537836944547904423637136789783854836853497598720872599439703238350",
-		"20422616371558051321328641762545775674999844341281317444259962231474038621913",
-		"70721761249807583889417427160262976417209826966551495121360338241351139517252",
-		"67154409164492473022545374284896465659184269211491885695865972146826122887517",
-		"14641621711817804362131083482888050433544024750770175084260876296122465116391",
-		"44449413951567356658450979332805732743618650826831158077837287657342508935054",
-		"49888894850490203642386712655024128420501187089752845687448626231471203382973",
-	}
-	for i := 0; i < 16; i++ {
-		b := new(big.Int)
-		b.SetString(pre[i], 10)
-		bbuf := make([]byte, 32)
-		b.FillBytes(bbuf)
-		for j := 0; j < 32; j++ {
-			bytes[32*i+j] = bbuf[j]
+		return nil, err
+	}
+	return k, prover.CheckConstraints(k, ccs)
+}
+
+func setupCmd(args []string) error {
+	fs := flag.NewFlagSet("setup", flag.ContinueOnError)
+	dir := fs.String("dir", defaultDir, "key directory")
+	importPK := fs.String("import-pk", "", "proving key of an external setup, instead of an insecure local one")
+	importVK := fs.String("import-vk", "", "verifying key of an external setup")
+	force := fs.Bool("force", false, "replace the keys of another circuit, which are refused otherwise, and check their constraints")
+	check := fs.Bool("check", false, "compile the circuit and refuse keys of other constraints")
+	f := addCircuitFlags(fs)
+	if err := parse(fs, args, "dir"); err != nil {
+		return err
+	}
+	if err := f.check(); err != nil {
+		return err
+	}
+	if (*importPK == "") != (*importVK == "") {
+		return fmt.Errorf("%w: -import-pk and -import-vk go together", errUsage)
+	}
+	if *importPK == "" {
+		fmt.Fprintln(os.Stderr, "warning: a local setup is insecure, production keys come from -import-pk and -import-vk")
+		k, err := prover.LoadOrSetup(*dir, f.config, f.nbBatches, *force)
+		if err == nil && *check && !*force {
+			k, err = loadKeys(*dir, f.config, f.nbBatches, true)
+		}
+		if err != nil {
+			return forceHint(err)
 		}
+		fmt.Printf("circuit %s: keys in %s\n", k.Hash, *dir)
+		return nil
 	}
-	for i := 0; i < 512; i++ {
-		assignment.Preimage[i] = bytes[i]
+	h, err := prover.Hash(f.config, f.nbBatches)
+	if err != nil {
+		return err
 	}
-	witness, err2 := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
-	if err2 != nil {
-		fmt.Println("witness error :", err2)
+	ccs, err := prover.CompileBatches(f.config, f.nbBatches)
+	if err != nil {
+		return err
 	}
-	publicWitness, err3 := witness.Public()
-	if err3 != nil {
-		fmt.Println("public witness error :", err3)
+	k := &prover.Keys{Hash: h, NbBatches: f.nbBatches, CCS: ccs}
+	if k.PK, err = prover.ReadProvingKey(*importPK); err != nil {
+		return err
 	}
-	// groth16: Prove & Verify
-	proof, err4 := groth16.Prove(ccs, pk, witness)
-	if err4 != nil {
-		fmt.Println("proof error :", err4)
+	if k.VK, err = prover.ReadVerifyingKey(*importVK); err != nil {
+		return err
 	}
-	err5 := groth16.Verify(proof, vk, publicWitness)
-	if err5 != nil {
-		fmt.Printf("verification failed\n")
-		return
+	if err := prover.CheckKeys(ccs, k.PK, k.VK); err != nil {
+		return err
+	}
+	return forceHint(prover.Save(*dir, k, *force))
+}
+
+// notesFile lists note hashes, as decimal or 0x-prefixed hexadecimal integers.
+type notesFile struct {
+	// Tree holds the note hashes already in the tree, in insertion order, partial batches
+	// padded with zeros.
+	Tree []string `json:"tree"`
+	// Batch holds the note hashes of the next batch, or of the next -batches batches one
+	// after the other.
+	Batch []string `json:"batch"`
+}
+
+func parseNoteHashes(in []string) ([][32]byte, error) {
+	res := make([][32]byte, len(in))
+	for i, s := range in {
+		b, ok := new(big.Int).SetString(s, 0)
+		if !ok || b.Sign() < 0 || b.BitLen() > 256 {
+			return nil, fmt.Errorf("invalid note hash %q", s)
+		}
+		b.FillBytes(res[i][:])
+	}
+	return res, nil
+}
+
+func witnessCmd(args []string) error {
+	fs := flag.NewFlagSet("witness", flag.ContinueOnError)
+	notesPath := fs.String("notes", "", "input JSON note hashes {\"tree\": [...], \"batch\": [...]}")
+	out := fs.String("out", "witness.json", "output JSON witness")
+	f := addCircuitFlags(fs)
+	if err := parse(fs, args, "notes", "out"); err != nil {
+		return err
+	}
+	if err := f.check(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(*notesPath)
+	if err != nil {
+		return err
+	}
+	var notes notesFile
+	if err := json.Unmarshal(data, &notes); err != nil {
+		return err
+	}
+	inserted, err := parseNoteHashes(notes.Tree)
+	if err != nil {
+		return err
+	}
+	batch, err := parseNoteHashes(notes.Batch)
+	if err != nil {
+		return err
+	}
+	t, err := tree.NewWithParams(f.config.TreeParams())
+	if err != nil {
+		return err
+	}
+	for _, h := range inserted {
+		if err := t.Insert(tree.NoteLeaf(h)); err != nil {
+			return err
+		}
 	}
-	fmt.Printf("verification succeded\n")
+	var full witness.Witness
+	if f.nbBatches == 1 {
+		w, err := f.config.BuildWitness(t, batch)
+		if err != nil {
+			return err
+		}
+		full = w.Full
+	} else {
+		if len(batch) != f.nbBatches*f.config.BatchSize {
+			return fmt.Errorf("%d note hashes for %d batches of %d", len(batch), f.nbBatches, f.config.BatchSize)
+		}
+		batches := make([][][32]byte, f.nbBatches)
+		for i := range batches {
+			batches[i] = batch[i*f.config.BatchSize : (i+1)*f.config.BatchSize]
+		}
+		w, err := f.config.BuildMultiWitness(t, batches)
+		if err != nil {
+			return err
+		}
+		full = w.Full
+	}
+	if data, err = prover.MarshalWitness(f.config, f.nbBatches, full); err != nil {
+		return err
+	}
+	return prover.WriteFile(*out, bytes.NewReader(data))
+}
+
+func proveCmd(args []string) error {
+	fs := flag.NewFlagSet("prove", flag.ContinueOnError)
+	dir := fs.String("dir", defaultDir, "key directory")
+	witnessPath := fs.String("witness", "witness.json", "input JSON witness")
+	proofPath := fs.String("proof", "proof", "output proof")
+	publicPath := fs.String("public", "", "output JSON public witness")
+	check := fs.Bool("check", false, "compile the circuit and refuse keys of other constraints")
+	f := addCircuitFlags(fs)
+	if err := parse(fs, args, "dir", "witness", "proof"); err != nil {
+		return err
+	}
+	if err := f.check(); err != nil {
+		return err
+	}
+	k, err := loadKeys(*dir, f.config, f.nbBatches, *check)
+	if err != nil {
+		return err
+	}
+	w, err := f.readWitness(*witnessPath)
+	if err != nil {
+		return err
+	}
+	proof, err := groth16.Prove(k.CCS, k.PK, w)
+	if err != nil {
+		return err
+	}
+	if err := prover.WriteFile(*proofPath, proof); err != nil {
+		return err
+	}
+	if *publicPath == "" {
+		return nil
+	}
+	public, err := w.Public()
+	if err != nil {
+		return err
+	}
+	data, err := prover.MarshalWitness(f.config, f.nbBatches, public)
+	if err != nil {
+		return err
+	}
+	return prover.WriteFile(*publicPath, bytes.NewReader(data))
+}
+
+func verifyCmd(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	dir := fs.String("dir", defaultDir, "key directory")
+	vkPath := fs.String("vk", "", "input verifying key, the one of the circuit in -dir if empty")
+	proofPath := fs.String("proof", "proof", "input proof")
+	witnessPath := fs.String("witness", "", "input JSON witness, full or public")
+	f := addCircuitFlags(fs)
+	if err := parse(fs, args, "proof", "witness"); err != nil {
+		return err
+	}
+	if err := f.check(); err != nil {
+		return err
+	}
+	vk, err := f.verifyingKey(*dir, *vkPath)
+	if err != nil {
+		return err
+	}
+	proof, err := prover.ReadProof(*proofPath)
+	if err != nil {
+		return err
+	}
+	w, err := f.readWitness(*witnessPath)
+	if err != nil {
+		return err
+	}
+	public, err := w.Public()
+	if err != nil {
+		return err
+	}
+	if err := groth16.Verify(proof, vk, public); err != nil {
+		return err
+	}
+	fmt.Println("proof verified")
+	return nil
+}
+
+func inputsCmd(args []string) error {
+	fs := flag.NewFlagSet("inputs", flag.ContinueOnError)
+	witnessPath := fs.String("witness", "", "input JSON witness, full or public")
+	f := addCircuitFlags(fs)
+	if err := parse(fs, args, "witness"); err != nil {
+		return err
+	}
+	if err := f.check(); err != nil {
+		return err
+	}
+	w, err := f.readWitness(*witnessPath)
+	if err != nil {
+		return err
+	}
+	public, err := w.Public()
+	if err != nil {
+		return err
+	}
+	for _, v := range public.Vector().(fr.Vector) {
+		fmt.Println(v.String())
+	}
+	return nil
 }
 
-//assignment := subtreeUpdateCircuit{
-//	AccumulatorHash:    "1718694914574393558977766615778936430810912460753664150190225630968184786885",
-//	EncodedPathAndHash: "268435456",
-//	OldRoot:            "9533201250583817767896570092866591469094150406835227552485691564931228351592",
-//	NewRoot:            "14751455653696551972598626902324480412263087291693189381022091614544374105930",
-//	SubtreeMembershipProof: merkle.MerkleProof{
-//		RootHash: "14751455653696551972598626902324480412263087291693189381022091614544374105930",
-//		Leaf:     "19308544306448469788048112111319950102602262858931723855938361542409857985469",
-//		PathIndices: [14][2]frontend.Variable{
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//		},
-//		Siblings: [14][3]frontend.Variable{
-//			{
-//				"13867732332339151465497925642082178974038372652152621168903203076445231043372",
-//				"13867732332339151465497925642082178974038372652152621168903203076445231043372",
-//				"13867732332339151465497925642082178974038372652152621168903203076445231043372",
-//			},
-//			{
-//				"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-//				"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-//				"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-//			},
-//			{
-//				"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-//				"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-//				"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-//			},
-//			{
-//				"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-//				"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-//				"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-//			},
-//			{
-//				"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-//				"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-//				"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-//			},
-//			{
-//				"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-//				"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-//				"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-//			},
-//			{
-//				"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-//				"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-//				"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-//			},
-//			{
-//				"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-//				"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-//				"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-//			},
-//			{
-//				"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-//				"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-//				"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-//			},
-//			{
-//				"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-//				"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-//				"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-//			},
-//			{
-//				"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-//				"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-//				"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-//			},
-//			{
-//				"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-//				"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-//				"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-//			},
-//			{
-//				"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-//				"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-//				"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-//			},
-//			{
-//				"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-//				"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-//				"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-//			},
-//		},
-//	},
-//	EmptySubtreeMembershipProof: merkle.MerkleProof{
-//		RootHash: "9533201250583817767896570092866591469094150406835227552485691564931228351592",
-//		Leaf:     "13867732332339151465497925642082178974038372652152621168903203076445231043372",
-//		PathIndices: [14][2]frontend.Variable{
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//			{0, 0},
-//		},
-//		Siblings: [14][3]frontend.Variable{
-//			{
-//				"13867732332339151465497925642082178974038372652152621168903203076445231043372",
-//				"13867732332339151465497925642082178974038372652152621168903203076445231043372",
-//				"13867732332339151465497925642082178974038372652152621168903203076445231043372",
-//			},
-//			{
-//				"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-//				"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-//				"12482638920258770416445069396084160696706909713694235655126678204295434816978",
-//			},
-//			{
-//				"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-//				"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-//				"7166733538749145097044835779501529184583030407883999216862943858482812218900",
-//			},
-//			{
-//				"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-//				"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-//				"8105111289764799261118761045803039859349056024712051667352901171623997110135",
-//			},
-//			{
-//				"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-//				"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-//				"12848805869306190323636404927060402794679102677145469772979902111093008224192",
-//			},
-//			{
-//				"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-//				"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-//				"19558787758992270753559504143061140356952449644512620323370068802764968601369",
-//			},
-//			{
-//				"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-//				"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-//				"12390767703375336354386586159705861191789202938804190201641874256578171062369",
-//			},
-//			{
-//				"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-//				"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-//				"3137401155887568054342462681996579107920888172758509452834253176053621234802",
-//			},
-//			{
-//				"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-//				"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-//				"17246586734894168265112266963200606530710644744521791774170567941230295667185",
-//			},
-//			{
-//				"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-//				"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-//				"3579753298464347968175446964060349222759138522794226670675282662699379573112",
-//			},
-//			{
-//				"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-//				"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-//				"5502479177772891194542009540012941542204094004749813714118505924674776464255",
-//			},
-//			{
-//				"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-//				"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-//				"12023797624857124786560101326438313436313441099989701132514668150307392465028",
-//			},
-//			{
-//				"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-//				"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-//				"10263213503339600742925101484637942948945771652266922481751100499603709320717",
-//			},
-//			{
-//				"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-//				"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-//				"20734118650853257426634229445255987190193218607444720047392808113569367837624",
-//			},
-//		},
-//	},
-//	Preimage: make([]frontend.Variable, 512),
-//	Leaves: [16]frontend.Variable{
-//		"7559412695850999704437639814226631134667359700514660715427262528648684612384",
-//		"66128905217727820142075711671179697108908215459957692935244063164243782161424",
-//		"51015742989614192140374653588448216776344032110315281841496138794886522140476",
-//		"35122383026158949466484037373710698093278849499198161694631609784776227649041",
-//		"26172153391189409300153675552195806917108259526780793769448239894068277983117",
-//		"26319945699020872042776764262800211039811709625022690029869433243912894238514",
-//		"85459787920741173308529179304076764583420917452546478748737995277072485407899",
-//		"37824474589860659728395896987471423117349358731852046326799624553171445743149",
-//		"61194402916979300094031158454825880129228850504669718400883285170758259346137",
-//		"24246882173524206786121934947990875280633571158623508995012743986254503068477",
-//		"93199772650225608593183888507906173669398210074847791089995208298919581733292",
-//		"33909163889869673678628757617712328003205266681277740626071514924998877887543",
-//		"57565043458143669655443451912855736172750697736668570413272031819358780748047",
-//		"37243082771427767710089206757934373481061954243301630231967571484585860082658",
-//		"16563559798946351326855328924389131968545894673507955726309781333266729822892",
-//		"48994785319657803905781873709543292037955196759232529867686143523322370022071",
-//	},
-//}
+// suiExport holds the arguments of sui::groth16, as 0x-prefixed hexadecimal vector<u8>.
+type suiExport struct {
+	VerifyingKey string `json:"verifyingKey"`
+	Proof        string `json:"proof,omitempty"`
+	PublicInputs string `json:"publicInputs,omitempty"`
+}
 
-//pre := [16]string{
-//	"7559412695850999704437639814226631134667359700514660715427262528648684612384",
-//	"66128905217727820142075711671179697108908215459957692935244063164243782161424",
-//	"51015742989614192140374653588448216776344032110315281841496138794886522140476",
-//	"35122383026158949466484037373710698093278849499198161694631609784776227649041",
-//	"26172153391189409300153675552195806917108259526780793769448239894068277983117",
-//	"26319945699020872042776764262800211039811709625022690029869433243912894238514",
-//	"85459787920741173308529179304076764583420917452546478748737995277072485407899",
-//	"37824474589860659728395896987471423117349358731852046326799624553171445743149",
-//	"61194402916979300094031158454825880129228850504669718400883285170758259346137",
-//	"24246882173524206786121934947990875280633571158623508995012743986254503068477",
-//	"93199772650225608593183888507906173669398210074847791089995208298919581733292",
-//	"33909163889869673678628757617712328003205266681277740626071514924998877887543",
-//	"57565043458143669655443451912855736172750697736668570413272031819358780748047",
-//	"37243082771427767710089206757934373481061954243301630231967571484585860082658",
-//	"16563559798946351326855328924389131968545894673507955726309781333266729822892",
-//	"48994785319657803905781873709543292037955196759232529867686143523322370022071",
-//}
\ No newline at end of file
+func exportSui(f *circuitFlags, vk groth16.VerifyingKey, proofPath, witnessPath string) ([]byte, error) {
+	var res suiExport
+	b, err := export.SuiVerifyingKey(vk)
+	if err != nil {
+		return nil, err
+	}
+	res.VerifyingKey = "0x" + hex.EncodeToString(b)
+	if proofPath != "" {
+		proof, err := prover.ReadProof(proofPath)
+		if err != nil {
+			return nil, err
+		}
+		if b, err = export.SuiProof(proof); err != nil {
+			return nil, err
+		}
+		res.Proof = "0x" + hex.EncodeToString(b)
+	}
+	if witnessPath != "" {
+		w, err := f.readWitness(witnessPath)
+		if err != nil {
+			return nil, err
+		}
+		public, err := w.Public()
+		if err != nil {
+			return nil, err
+		}
+		if b, err = export.SuiPublicInputs(public); err != nil {
+			return nil, err
+		}
+		res.PublicInputs = "0x" + hex.EncodeToString(b)
+	}
+	return json.MarshalIndent(res, "", "  ")
+}
+
+func exportCalldata(f *circuitFlags, proofPath, witnessPath string) ([]byte, error) {
+	if proofPath == "" || witnessPath == "" {
+		return nil, fmt.Errorf("%w: calldata needs -proof and -witness", errUsage)
+	}
+	proof, err := prover.ReadProof(proofPath)
+	if err != nil {
+		return nil, err
+	}
+	w, err := f.readWitness(witnessPath)
+	if err != nil {
+		return nil, err
+	}
+	public, err := w.Public()
+	if err != nil {
+		return nil, err
+	}
+	return export.SolidityCalldata(proof, public)
+}
+
+func exportCmd(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dir := fs.String("dir", defaultDir, "key directory")
+	vkPath := fs.String("vk", "", "input verifying key, the one of the circuit in -dir if empty")
+	format := fs.String("format", "solidity", "output format: solidity for the verifier contract, calldata for its verifyProof call, or sui for the arguments of sui::groth16")
+	proofPath := fs.String("proof", "", "input proof, for -format calldata and sui")
+	witnessPath := fs.String("witness", "", "input JSON witness whose public inputs are exported, for -format calldata and sui")
+	out := fs.String("out", "", "output file, standard output if empty")
+	f := addCircuitFlags(fs)
+	if err := parse(fs, args, "format"); err != nil {
+		return err
+	}
+	if err := f.check(); err != nil {
+		return err
+	}
+	vk, err := f.verifyingKey(*dir, *vkPath)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	switch *format {
+	case "solidity":
+		err = export.SolidityVerifier(&buf, vk)
+	case "calldata":
+		var b []byte
+		if b, err = exportCalldata(f, *proofPath, *witnessPath); err == nil {
+			fmt.Fprintf(&buf, "0x%x\n", b)
+		}
+	case "sui":
+		var b []byte
+		if b, err = exportSui(f, vk, *proofPath, *witnessPath); err == nil {
+			buf.Write(append(b, '\n'))
+		}
+	default:
+		return fmt.Errorf("%w: unknown format %q", errUsage, *format)
+	}
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = io.Copy(os.Stdout, &buf)
+		return err
+	}
+	return prover.WriteFile(*out, &buf)
+}
+
+func updateCmd(args []string) error {
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	dir := fs.String("dir", defaultDir, "key directory")
+	chainURL := fs.String("chain", "", "URL of the chain relay, see updater.HTTPChain")
+	checkpoint := fs.String("checkpoint", "checkpoint.json", "checkpoint file, its leaves in the same path with .leaves appended")
+	poll := fs.Duration("poll", 5*time.Second, "wait between two looks at an empty queue")
+	multi := fs.String("multi", "", "comma-separated numbers of batches also proven at once, with the keys of setup -batches")
+	check := fs.Bool("check", false, "compile the circuits and refuse keys of other constraints")
+	if err := parse(fs, args, "dir", "chain", "checkpoint"); err != nil {
+		return err
+	}
+	k, err := loadKeys(*dir, circuit.DefaultConfig, 1, *check)
+	if err != nil {
+		return err
+	}
+	p := &updater.Groth16Prover{Config: circuit.DefaultConfig, Keys: k}
+	for _, f := range strings.FieldsFunc(*multi, func(r rune) bool { return r == ',' }) {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 2 {
+			return fmt.Errorf("%w: invalid number of batches %q", errUsage, f)
+		}
+		mk, err := loadKeys(*dir, circuit.DefaultConfig, n, *check)
+		if err != nil {
+			return err
+		}
+		p.Multi = append(p.Multi, mk)
+	}
+	u, err := updater.New(&updater.HTTPChain{URL: *chainURL}, p, updater.NewFileStore(*checkpoint))
+	if err != nil {
+		return err
+	}
+	u.PollInterval = *poll
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "circuit %s: updating from %d leaves\n", k.Hash, u.Count())
+	if err := u.Run(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
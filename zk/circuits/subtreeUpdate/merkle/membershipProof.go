@@ -1,64 +1,136 @@
 package merkle
 
 import (
+	"fmt"
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/std/hash"
+	"math/big"
 )
 
-// MerkleProof for quadtree.
+// MerkleProof of a node, allocated by Params.NewMerkleProof. Level i is the i-th level above
+// the node.
 type MerkleProof struct {
-	RootHash    frontend.Variable
-	Leaf        frontend.Variable
-	PathIndices [14][2]frontend.Variable
-	Siblings    [14][3]frontend.Variable
+	RootHash frontend.Variable
+	Leaf     frontend.Variable
+	// PathIndices[i] are the bits of the position of the path among the children of level
+	// i, most significant first: {hi, lo} for position 2*hi+lo in a quadtree.
+	PathIndices [][]frontend.Variable
+	// Siblings[i] are the other children of level i, in position order.
+	Siblings [][]frontend.Variable
 }
 
-// 16 leaves, 2 depth
-func ComputeRootFromLeaves(api frontend.API, h hash.Hash, leaves [16]frontend.Variable) frontend.Variable {
-	middleLevelNodes := [4]frontend.Variable{
-		frontend.Variable(0),
-		frontend.Variable(0),
-		frontend.Variable(0),
-		frontend.Variable(0),
+// ComputeRootFromLeaves hashes the SubtreeSize leaves of a subtree up to its root.
+func (p Params) ComputeRootFromLeaves(api frontend.API, h hash.Hash, leaves []frontend.Variable) frontend.Variable {
+	if len(leaves) != p.SubtreeSize() {
+		panic(fmt.Sprintf("merkle: %d leaves, subtree has %d", len(leaves), p.SubtreeSize()))
 	}
-	for i := 0; i < 4; i++ {
-		middleLevelNodes[i] = nodeSum(api, h, leaves[4*i], leaves[4*i+1], leaves[4*i+2], leaves[4*i+3])
+	level := leaves
+	for len(level) > 1 {
+		next := make([]frontend.Variable, len(level)/p.Arity)
+		for i := range next {
+			next[i] = nodeSum(h, level[p.Arity*i:p.Arity*(i+1)]...)
+		}
+		level = next
 	}
-	return nodeSum(api, h, middleLevelNodes[0], middleLevelNodes[1], middleLevelNodes[2], middleLevelNodes[3])
+	return level[0]
 }
 
+// ComputePath returns the index of the node proven by mp among the nodes of its level, the
+// little-endian number whose digit i, in base Arity, is the position of the path at level
+// i. It asserts that PathIndices are boolean, so that every path has a single index.
 func (mp *MerkleProof) ComputePath(api frontend.API) frontend.Variable {
-	res := frontend.Variable("0")
-	t := frontend.Variable("1")
-	for i := 0; i < 14; i++ {
-		index := api.Add(api.Mul(mp.PathIndices[i][0], frontend.Variable("2")), mp.PathIndices[i][1])
-		res = api.Add(res, api.Mul(index, t))
-		t = api.Mul(t, frontend.Variable("2"))
+	assertPathIsBoolean(api, mp.PathIndices)
+	res := frontend.Variable(0)
+	shift := 0
+	for i := range mp.PathIndices {
+		k := len(mp.PathIndices[i])
+		for j, b := range mp.PathIndices[i] {
+			weight := new(big.Int).Lsh(big.NewInt(1), uint(shift+k-1-j))
+			res = api.Add(res, api.Mul(b, weight))
+		}
+		shift += k
 	}
 	return res
 }
 
-// return 4 nodes hashSum
-func nodeSum(api frontend.API, h hash.Hash, a, b, c, d frontend.Variable) frontend.Variable {
+func assertPathIsBoolean(api frontend.API, pathIndices [][]frontend.Variable) {
+	for i := range pathIndices {
+		for _, b := range pathIndices[i] {
+			api.AssertIsBoolean(b)
+		}
+	}
+}
+
+// return the hash of the children of a node
+func nodeSum(h hash.Hash, children ...frontend.Variable) frontend.Variable {
 
 	h.Reset()
-	h.Write(a, b, c, d)
+	h.Write(children...)
 	res := h.Sum()
 
 	return res
 }
 
+// VerifyProof asserts that Leaf is at PathIndices in the tree of root RootHash.
 func (mp *MerkleProof) VerifyProof(api frontend.API, h hash.Hash) {
+	assertPathIsBoolean(api, mp.PathIndices)
+	api.AssertIsEqual(ComputeRoot(api, h, mp.Leaf, mp.PathIndices, mp.Siblings), mp.RootHash)
+}
 
-	current := mp.Leaf
+// ComputeRoot hashes node up to the root along the given path. pathIndices must be boolean,
+// the arity of every level is one more than its number of siblings.
+func ComputeRoot(api frontend.API, h hash.Hash, node frontend.Variable, pathIndices, siblings [][]frontend.Variable) frontend.Variable {
+	if len(pathIndices) != len(siblings) {
+		panic(fmt.Sprintf("merkle: %d path indices for %d levels of siblings", len(pathIndices), len(siblings)))
+	}
 
-	for i := 0; i < len(mp.PathIndices); i++ {
-		d1 := api.Lookup2(mp.PathIndices[i][0], mp.PathIndices[i][1], current, mp.Siblings[i][0], mp.Siblings[i][0], mp.Siblings[i][0])
-		d2 := api.Lookup2(mp.PathIndices[i][0], mp.PathIndices[i][1], mp.Siblings[i][1], mp.Siblings[i][1], current, mp.Siblings[i][2])
-		d3 := api.Lookup2(mp.PathIndices[i][0], mp.PathIndices[i][1], mp.Siblings[i][0], current, mp.Siblings[i][1], mp.Siblings[i][1])
-		d4 := api.Lookup2(mp.PathIndices[i][0], mp.PathIndices[i][1], mp.Siblings[i][2], mp.Siblings[i][2], mp.Siblings[i][2], current)
-		current = nodeSum(api, h, d1, d2, d3, d4)
+	current := node
+
+	for i := range pathIndices {
+		arity := len(siblings[i]) + 1
+		if 1<<len(pathIndices[i]) != arity {
+			panic(fmt.Sprintf("merkle: level %d has %d siblings and %d index bits", i, len(siblings[i]), len(pathIndices[i])))
+		}
+		// index bits least significant first, as mux takes them
+		indexBits := make([]frontend.Variable, len(pathIndices[i]))
+		for j := range indexBits {
+			indexBits[j] = pathIndices[i][len(indexBits)-1-j]
+		}
+		// child c is current at position c, siblings[i][c] before it and siblings[i][c-1]
+		// after it
+		children := make([]frontend.Variable, arity)
+		for c := range children {
+			candidates := make([]frontend.Variable, arity)
+			for position := range candidates {
+				switch {
+				case position == c:
+					candidates[position] = current
+				case position > c:
+					candidates[position] = siblings[i][c]
+				default:
+					candidates[position] = siblings[i][c-1]
+				}
+			}
+			children[c] = mux(api, indexBits, candidates)
+		}
+		current = nodeSum(h, children...)
 	}
 
-	api.AssertIsEqual(current, mp.RootHash)
+	return current
+}
+
+// mux returns in[index], index given by its bits, least significant first. Two bits are
+// selected by one Lookup2, taking the high bit first: the candidates of a child often
+// repeat its sibling and that order saves constraints.
+func mux(api frontend.API, indexBits []frontend.Variable, in []frontend.Variable) frontend.Variable {
+	switch len(indexBits) {
+	case 1:
+		return api.Select(indexBits[0], in[1], in[0])
+	case 2:
+		return api.Lookup2(indexBits[1], indexBits[0], in[0], in[2], in[1], in[3])
+	}
+	// split on the most significant bit
+	top := len(indexBits) - 1
+	half := len(in) / 2
+	return api.Select(indexBits[top], mux(api, indexBits[:top], in[half:]), mux(api, indexBits[:top], in[:half]))
 }
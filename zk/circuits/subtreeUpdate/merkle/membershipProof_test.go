@@ -0,0 +1,252 @@
+package merkle_test
+
+import (
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"math/big"
+	"subtreeUpdate/merkle"
+	"subtreeUpdate/poseidon"
+	"subtreeUpdate/tree"
+	"testing"
+)
+
+type computePathCircuit struct {
+	Proof merkle.MerkleProof
+	Path  frontend.Variable `gnark:",public"`
+}
+
+func (c *computePathCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(c.Proof.ComputePath(api), c.Path)
+	c.Proof.VerifyProof(api, poseidon.NewPoseidonHash(api))
+	return nil
+}
+
+// smallTree fills the first 3 levels of subtrees, 64 batches.
+func smallTree(t *testing.T) *tree.Tree {
+	tr := tree.New()
+	for i := 0; i < 64; i++ {
+		batch := make([]*big.Int, tree.BatchSize)
+		for j := range batch {
+			batch[j] = big.NewInt(int64(i*tree.BatchSize + j + 1))
+		}
+		if err := tr.InsertBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tr
+}
+
+func subtreeProof(t *testing.T, tr *tree.Tree, index uint64) merkle.MerkleProof {
+	p, err := tr.ProveSubtree(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.MerkleProof()
+}
+
+// TestComputePath enumerates the subtrees of a small tree, and a few of the last ones, and
+// checks that the path of each is its index.
+func TestComputePath(t *testing.T) {
+	tr := smallTree(t)
+	var indices []uint64
+	for i := uint64(0); i < 64; i++ {
+		indices = append(indices, i)
+	}
+	last := uint64(1)<<(2*(tree.Depth-tree.BatchSubtreeDepth)) - 1
+	indices = append(indices, 1<<26, last/3, last-1, last)
+	circuit := &computePathCircuit{Proof: merkle.Quad16.NewSubtreeProof()}
+	for _, idx := range indices {
+		mp := subtreeProof(t, tr, idx)
+		if err := test.IsSolved(circuit, &computePathCircuit{Proof: mp, Path: idx}, ecc.BN254.ScalarField()); err != nil {
+			t.Fatalf("subtree %d: %v", idx, err)
+		}
+		// the path of the next subtree is rejected
+		if err := test.IsSolved(circuit, &computePathCircuit{Proof: mp, Path: idx + 1}, ecc.BN254.ScalarField()); err == nil {
+			t.Fatalf("subtree %d: path %d accepted", idx, idx+1)
+		}
+	}
+}
+
+type pathOnlyCircuit struct {
+	Proof merkle.MerkleProof
+	Path  frontend.Variable `gnark:",public"`
+}
+
+func (c *pathOnlyCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(c.Proof.ComputePath(api), c.Path)
+	return nil
+}
+
+// TestComputePathRejectsNonBooleanIndices checks that a path index of 2 cannot stand for the
+// digit of {1, 0}, nor carry into the next level.
+func TestComputePathRejectsNonBooleanIndices(t *testing.T) {
+	mp := subtreeProof(t, smallTree(t), 0)
+	circuit := &pathOnlyCircuit{Proof: merkle.Quad16.NewSubtreeProof()}
+	if err := test.IsSolved(circuit, &pathOnlyCircuit{Proof: mp, Path: 0}, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		indices []frontend.Variable
+		path    int
+	}{
+		{[]frontend.Variable{0, 2}, 2},
+		{[]frontend.Variable{2, 0}, 4},
+		{[]frontend.Variable{0, -1}, -1},
+	} {
+		mp.PathIndices[0] = c.indices
+		if err := test.IsSolved(circuit, &pathOnlyCircuit{Proof: mp, Path: c.path}, ecc.BN254.ScalarField()); err == nil {
+			t.Fatalf("path indices %v accepted", c.indices)
+		}
+	}
+}
+
+// nativeTree holds the levels of a full tree of p, built with the native Poseidon.
+type nativeTree struct {
+	p      merkle.Params
+	levels [][]*big.Int
+}
+
+func newNativeTree(p merkle.Params, leaves []*big.Int) *nativeTree {
+	nt := &nativeTree{p: p, levels: [][]*big.Int{leaves}}
+	for level := leaves; len(level) > 1; {
+		next := make([]*big.Int, len(level)/p.Arity)
+		for i := range next {
+			next[i] = poseidon.PoseidonNative(level[p.Arity*i : p.Arity*(i+1)]...)
+		}
+		nt.levels = append(nt.levels, next)
+		level = next
+	}
+	return nt
+}
+
+// prove returns the proof of node index of the given height.
+func (nt *nativeTree) prove(height int, index int) merkle.MerkleProof {
+	levels := nt.levels[height:]
+	mp := nt.p.NewMerkleProof(len(levels) - 1)
+	mp.RootHash = levels[len(levels)-1][0]
+	mp.Leaf = levels[0][index]
+	for i := range mp.Siblings {
+		digit := index % nt.p.Arity
+		for b := range mp.PathIndices[i] {
+			mp.PathIndices[i][b] = (digit >> (len(mp.PathIndices[i]) - 1 - b)) & 1
+		}
+		j := 0
+		for c := 0; c < nt.p.Arity; c++ {
+			if c != digit {
+				mp.Siblings[i][j] = levels[i][index-digit+c]
+				j++
+			}
+		}
+		index /= nt.p.Arity
+	}
+	return mp
+}
+
+type subtreeCircuit struct {
+	params merkle.Params
+	Leaves []frontend.Variable
+	Proof  merkle.MerkleProof
+	Path   frontend.Variable `gnark:",public"`
+}
+
+func (c *subtreeCircuit) Define(api frontend.API) error {
+	h := poseidon.NewPoseidonHash(api)
+	api.AssertIsEqual(c.params.ComputeRootFromLeaves(api, h, c.Leaves), c.Proof.Leaf)
+	api.AssertIsEqual(c.Proof.ComputePath(api), c.Path)
+	c.Proof.VerifyProof(api, h)
+	return nil
+}
+
+// TestParams builds small trees of every arity and checks the proof of every subtree.
+func TestParams(t *testing.T) {
+	for _, p := range []merkle.Params{
+		{Arity: 2, Depth: 5, SubtreeDepth: 2},
+		{Arity: 4, Depth: 3, SubtreeDepth: 1},
+		{Arity: 8, Depth: 2, SubtreeDepth: 1},
+		{Arity: 8, Depth: 2, SubtreeDepth: 0},
+		{Arity: 2, Depth: 3, SubtreeDepth: 3},
+	} {
+		if err := p.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		nbLeaves := 1 << (p.IndexBits() * p.Depth)
+		leaves := make([]*big.Int, nbLeaves)
+		for i := range leaves {
+			leaves[i] = big.NewInt(int64(i + 1))
+		}
+		nt := newNativeTree(p, leaves)
+		circuit := &subtreeCircuit{
+			params: p,
+			Leaves: make([]frontend.Variable, p.SubtreeSize()),
+			Proof:  p.NewSubtreeProof(),
+		}
+		for index := 0; index < nbLeaves/p.SubtreeSize(); index++ {
+			assignment := &subtreeCircuit{
+				Leaves: make([]frontend.Variable, p.SubtreeSize()),
+				Proof:  nt.prove(p.SubtreeDepth, index),
+				Path:   index,
+			}
+			for i := range assignment.Leaves {
+				assignment.Leaves[i] = leaves[index*p.SubtreeSize()+i]
+			}
+			if err := test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()); err != nil {
+				t.Fatalf("%+v, subtree %d: %v", p, index, err)
+			}
+			// the siblings of a node must be in position order
+			if p.Arity > 2 {
+				s := assignment.Proof.Siblings[0]
+				s[0], s[1] = s[1], s[0]
+				if err := test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()); err == nil {
+					t.Fatalf("%+v, subtree %d: swapped siblings accepted", p, index)
+				}
+			}
+		}
+	}
+}
+
+func TestQuad16MatchesTree(t *testing.T) {
+	if tree.Params != merkle.Quad16 {
+		t.Fatalf("tree.Params = %+v, want merkle.Quad16 %+v", tree.Params, merkle.Quad16)
+	}
+	if merkle.Quad16.SubtreeSize() != tree.BatchSize || merkle.Quad16.PathDepth() != 14 {
+		t.Fatalf("Quad16 has subtrees of %d leaves, %d levels below the root", merkle.Quad16.SubtreeSize(), merkle.Quad16.PathDepth())
+	}
+}
+
+func TestNewSubtreeProof(t *testing.T) {
+	for _, c := range []struct {
+		p                       merkle.Params
+		subtreeSize, pathLevels int
+	}{
+		{merkle.Params{Arity: 4, Depth: 20, SubtreeDepth: 1}, 4, 19},
+		{merkle.Params{Arity: 4, Depth: 32, SubtreeDepth: 3}, 64, 29},
+		{merkle.Params{Arity: 2, Depth: 32, SubtreeDepth: 4}, 16, 28},
+		{merkle.Params{Arity: 8, Depth: 20, SubtreeDepth: 2}, 64, 18},
+	} {
+		if err := c.p.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		mp := c.p.NewSubtreeProof()
+		if c.p.SubtreeSize() != c.subtreeSize || len(mp.Siblings) != c.pathLevels || len(mp.PathIndices) != c.pathLevels {
+			t.Fatalf("%+v: subtrees of %d leaves, %d levels", c.p, c.p.SubtreeSize(), len(mp.Siblings))
+		}
+		if len(mp.Siblings[0]) != c.p.Arity-1 || len(mp.PathIndices[0]) != c.p.IndexBits() {
+			t.Fatalf("%+v: %d siblings, %d index bits", c.p, len(mp.Siblings[0]), len(mp.PathIndices[0]))
+		}
+	}
+}
+
+func TestInvalidParams(t *testing.T) {
+	for _, p := range []merkle.Params{
+		{Arity: 3, Depth: 4, SubtreeDepth: 1},
+		{Arity: 16, Depth: 4, SubtreeDepth: 1},
+		{Arity: 4, Depth: 1, SubtreeDepth: 2},
+		{Arity: 4, Depth: 33},
+		{Arity: 2, Depth: 4, SubtreeDepth: -1},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("%+v is valid", p)
+		}
+	}
+}
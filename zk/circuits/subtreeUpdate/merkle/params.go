@@ -0,0 +1,68 @@
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"github.com/consensys/gnark/frontend"
+	"math/bits"
+)
+
+var ErrInvalidParams = errors.New("merkle: invalid tree parameters")
+
+// Params describe the shape of a tree: every node has Arity children, Depth levels lie above
+// the leaves, and the subtrees filled by one update have SubtreeDepth levels.
+type Params struct {
+	Arity        int
+	Depth        int
+	SubtreeDepth int
+}
+
+// Quad16 is the tree of libs::offchain_merkle_tree: a quadtree of depth 16 updated by
+// subtrees of 16 leaves.
+var Quad16 = Params{Arity: 4, Depth: 16, SubtreeDepth: 2}
+
+// Validate checks that the arity is 2, 4 or 8, that the subtree fits in the tree and that
+// the tree has at most 2^64 leaves.
+func (p Params) Validate() error {
+	if p.Arity != 2 && p.Arity != 4 && p.Arity != 8 {
+		return fmt.Errorf("%w: arity %d, want 2, 4 or 8", ErrInvalidParams, p.Arity)
+	}
+	if p.SubtreeDepth < 0 || p.Depth < p.SubtreeDepth || p.Depth*p.IndexBits() > 64 {
+		return fmt.Errorf("%w: depth %d, subtree depth %d", ErrInvalidParams, p.Depth, p.SubtreeDepth)
+	}
+	return nil
+}
+
+// IndexBits is the number of bits of the position of a node among its siblings.
+func (p Params) IndexBits() int {
+	return bits.TrailingZeros(uint(p.Arity))
+}
+
+// SubtreeSize is the number of leaves of a subtree.
+func (p Params) SubtreeSize() int {
+	return 1 << (p.IndexBits() * p.SubtreeDepth)
+}
+
+// PathDepth is the number of levels between a subtree and the root.
+func (p Params) PathDepth() int {
+	return p.Depth - p.SubtreeDepth
+}
+
+// NewMerkleProof allocates the proof of a node depth levels below the root, to be assigned
+// or compiled.
+func (p Params) NewMerkleProof(depth int) MerkleProof {
+	mp := MerkleProof{
+		PathIndices: make([][]frontend.Variable, depth),
+		Siblings:    make([][]frontend.Variable, depth),
+	}
+	for i := 0; i < depth; i++ {
+		mp.PathIndices[i] = make([]frontend.Variable, p.IndexBits())
+		mp.Siblings[i] = make([]frontend.Variable, p.Arity-1)
+	}
+	return mp
+}
+
+// NewSubtreeProof allocates the proof of a subtree root.
+func (p Params) NewSubtreeProof() MerkleProof {
+	return p.NewMerkleProof(p.PathDepth())
+}
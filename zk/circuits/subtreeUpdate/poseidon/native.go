@@ -0,0 +1,160 @@
+package poseidon
+
+import (
+	"fmt"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"math/big"
+	"sync"
+)
+
+// nativeConstants holds POSEIDON_C/S/M/P for one width, parsed once into field elements.
+type nativeConstants struct {
+	c []fr.Element
+	s []fr.Element
+	m [][]fr.Element
+	p [][]fr.Element
+}
+
+var (
+	nativeConstantsMu    sync.Mutex
+	nativeConstantsCache = map[int]*nativeConstants{}
+)
+
+func toElements(in []*big.Int) []fr.Element {
+	out := make([]fr.Element, len(in))
+	for i, v := range in {
+		out[i].SetBigInt(v)
+	}
+	return out
+}
+
+func toMatrix(in [][]*big.Int) [][]fr.Element {
+	out := make([][]fr.Element, len(in))
+	for i, row := range in {
+		out[i] = toElements(row)
+	}
+	return out
+}
+
+func getNativeConstants(t int) *nativeConstants {
+	nativeConstantsMu.Lock()
+	defer nativeConstantsMu.Unlock()
+	if k, ok := nativeConstantsCache[t]; ok {
+		return k
+	}
+	k := &nativeConstants{
+		c: toElements(POSEIDON_C(t)),
+		s: toElements(POSEIDON_S(t)),
+		m: toMatrix(POSEIDON_M(t)),
+		p: toMatrix(POSEIDON_P(t)),
+	}
+	nativeConstantsCache[t] = k
+	return k
+}
+
+func sigmaNative(in *fr.Element) {
+	var in2, in4 fr.Element
+	in2.Square(in)
+	in4.Square(&in2)
+	in.Mul(&in4, in)
+}
+
+func arkNative(state []fr.Element, c []fr.Element, r int) {
+	for i := range state {
+		state[i].Add(&state[i], &c[i+r])
+	}
+}
+
+func mixNative(state []fr.Element, m [][]fr.Element) []fr.Element {
+	t := len(state)
+	out := make([]fr.Element, t)
+	var tmp fr.Element
+	for i := 0; i < t; i++ {
+		for j := 0; j < t; j++ {
+			tmp.Mul(&m[j][i], &state[j])
+			out[i].Add(&out[i], &tmp)
+		}
+	}
+	return out
+}
+
+// PoseidonExNative is the out-of-circuit counterpart of PoseidonEx: it runs the same
+// permutation with the same constants and returns the same nOuts field elements.
+func PoseidonExNative(inputs []fr.Element, initialState fr.Element, nOuts int) []fr.Element {
+	t := len(inputs) + 1
+	if t < 2 || t > len(nRoundsPC)+1 {
+		panic(fmt.Sprintf("poseidon: unsupported width t=%d, need 2 <= t <= %d", t, len(nRoundsPC)+1))
+	}
+	if nOuts < 1 || nOuts > t {
+		panic(fmt.Sprintf("poseidon: unsupported nOuts=%d for width t=%d", nOuts, t))
+	}
+	nRoundsF := 8
+	nRoundsP := nRoundsPC[t-2]
+	k := getNativeConstants(t)
+	c, s, m, p := k.c, k.s, k.m, k.p
+
+	state := make([]fr.Element, t)
+	state[0] = initialState
+	copy(state[1:], inputs)
+	arkNative(state, c, 0)
+
+	for r := 0; r < nRoundsF/2-1; r++ {
+		for j := 0; j < t; j++ {
+			sigmaNative(&state[j])
+		}
+		arkNative(state, c, (r+1)*t)
+		state = mixNative(state, m)
+	}
+
+	for j := 0; j < t; j++ {
+		sigmaNative(&state[j])
+	}
+	arkNative(state, c, nRoundsF/2*t)
+	state = mixNative(state, p)
+
+	var tmp fr.Element
+	for r := 0; r < nRoundsP; r++ {
+		sigmaNative(&state[0])
+		state[0].Add(&state[0], &c[(nRoundsF/2+1)*t+r])
+
+		var newState0 fr.Element
+		for j := 0; j < t; j++ {
+			tmp.Mul(&s[(t*2-1)*r+j], &state[j])
+			newState0.Add(&newState0, &tmp)
+		}
+		for j := 1; j < t; j++ {
+			tmp.Mul(&state[0], &s[(t*2-1)*r+t+j-1])
+			state[j].Add(&state[j], &tmp)
+		}
+		state[0] = newState0
+	}
+
+	for r := 0; r < nRoundsF/2-1; r++ {
+		for j := 0; j < t; j++ {
+			sigmaNative(&state[j])
+		}
+		arkNative(state, c, (nRoundsF/2+1)*t+nRoundsP+r*t)
+		state = mixNative(state, m)
+	}
+
+	for j := 0; j < t; j++ {
+		sigmaNative(&state[j])
+	}
+
+	out := make([]fr.Element, nOuts)
+	for i := 0; i < nOuts; i++ {
+		for j := 0; j < t; j++ {
+			tmp.Mul(&m[j][i], &state[j])
+			out[i].Add(&out[i], &tmp)
+		}
+	}
+	return out
+}
+
+// PoseidonNative is the out-of-circuit counterpart of Poseidon. Inputs are reduced modulo
+// the BN254 scalar field, exactly as they would be when assigned to circuit variables.
+func PoseidonNative(inputs ...*big.Int) *big.Int {
+	in := toElements(inputs)
+	out := PoseidonExNative(in, fr.Element{}, 1)
+	return out[0].BigInt(new(big.Int))
+}
@@ -0,0 +1,84 @@
+package poseidon
+
+import (
+	"fmt"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"math/big"
+	"testing"
+)
+
+type circuitPoseidonEx struct {
+	Inputs       []frontend.Variable
+	InitialState frontend.Variable
+	Outs         []frontend.Variable `gnark:",public"`
+}
+
+func (c *circuitPoseidonEx) Define(api frontend.API) error {
+	outs := PoseidonEx(api, c.Inputs, c.InitialState, len(c.Outs))
+	for i := range outs {
+		api.AssertIsEqual(outs[i], c.Outs[i])
+	}
+	return nil
+}
+
+func TestPoseidonNativeVector(t *testing.T) {
+	inputs := []*big.Int{}
+	for _, s := range []string{
+		"7559412695850999704437639814226631134667359700514660715427262528648684612384",
+		"66128905217727820142075711671179697108908215459957692935244063164243782161424",
+		"51015742989614192140374653588448216776344032110315281841496138794886522140476",
+		"35122383026158949466484037373710698093278849499198161694631609784776227649041",
+	} {
+		i, _ := new(big.Int).SetString(s, 10)
+		inputs = append(inputs, i)
+	}
+	want := "872275818087525509595217110752724528741045789284806621653152938717973556562"
+	if got := PoseidonNative(inputs...).String(); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestPoseidonExNativeMatchesGadget(t *testing.T) {
+	for width := 2; width <= 17; width++ {
+		t.Run(fmt.Sprintf("t=%d", width), func(t *testing.T) {
+			inputs := make([]fr.Element, width-1)
+			for i := range inputs {
+				inputs[i].SetRandom()
+			}
+			var initialState fr.Element
+			initialState.SetRandom()
+			outs := PoseidonExNative(inputs, initialState, width)
+
+			circuit := circuitPoseidonEx{
+				Inputs: make([]frontend.Variable, width-1),
+				Outs:   make([]frontend.Variable, width),
+			}
+			assignment := circuitPoseidonEx{
+				Inputs:       make([]frontend.Variable, width-1),
+				InitialState: initialState.BigInt(new(big.Int)),
+				Outs:         make([]frontend.Variable, width),
+			}
+			for i := range inputs {
+				assignment.Inputs[i] = inputs[i].BigInt(new(big.Int))
+			}
+			for i := range outs {
+				assignment.Outs[i] = outs[i].BigInt(new(big.Int))
+			}
+			if err := test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField()); err != nil {
+				t.Fatal(err)
+			}
+
+			// a single wrong output must be rejected
+			var one fr.Element
+			one.SetOne()
+			outs[width-1].Add(&outs[width-1], &one)
+			assignment.Outs[width-1] = outs[width-1].BigInt(new(big.Int))
+			if err := test.IsSolved(&circuit, &assignment, ecc.BN254.ScalarField()); err == nil {
+				t.Fatal("expected mismatching output to be rejected")
+			}
+		})
+	}
+}
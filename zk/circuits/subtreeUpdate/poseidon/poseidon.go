@@ -6,6 +6,11 @@ import (
 	"math/big"
 )
 
+// Using recommended parameters from whitepaper https://eprint.iacr.org/2019/458.pdf (table 2, table 8)
+// Generated by https://extgit.iaik.tugraz.at/krypto/hadeshash/-/blob/master/code/calc_round_numbers.py
+// And rounded up to nearest integer that divides by t
+var nRoundsPC = [16]int{56, 57, 56, 60, 60, 63, 64, 63, 60, 66, 60, 65, 70, 60, 64, 68}
+
 func Sigma(api frontend.API, in frontend.Variable) frontend.Variable {
 	in2 := api.Mul(in, in)
 	in4 := api.Mul(in2, in2)
@@ -60,10 +65,6 @@ func PoseidonEx(api frontend.API, inputs []frontend.Variable, initialState front
 	nInputs := len(inputs)
 	out := make([]frontend.Variable, nOuts)
 
-	// Using recommended parameters from whitepaper https://eprint.iacr.org/2019/458.pdf (table 2, table 8)
-	// Generated by https://extgit.iaik.tugraz.at/krypto/hadeshash/-/blob/master/code/calc_round_numbers.py
-	// And rounded up to nearest integer that divides by t
-	nRoundsPC := [16]int{56, 57, 56, 60, 60, 63, 64, 63, 60, 66, 60, 65, 70, 60, 64, 68}
 	t := nInputs + 1
 	nRoundsF := 8
 	nRoundsP := nRoundsPC[t-2]
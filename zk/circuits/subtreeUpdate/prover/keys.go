@@ -0,0 +1,372 @@
+package prover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"subtreeUpdate/circuit"
+)
+
+// Files of a key directory. Each one starts with the CircuitHash of the circuit it was
+// compiled or set up for, and the ConstraintHash of its constraint system. The files of
+// MultiSubtreeUpdateCircuit are named after the number of batches it inserts, see FileName.
+const (
+	R1CSFile         = "subtreeUpdate.r1cs"
+	ProvingKeyFile   = "subtreeUpdate.pk"
+	VerifyingKeyFile = "subtreeUpdate.vk"
+)
+
+// FileName returns the name of the file of the circuit inserting nbBatches batches at once,
+// name being the one of SubtreeUpdateCircuit: subtreeUpdate4.vk for 4 batches.
+func FileName(name string, nbBatches int) string {
+	if nbBatches <= 1 {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s%d%s", strings.TrimSuffix(name, ext), nbBatches, ext)
+}
+
+// CircuitVersion is hashed with the configuration of a circuit to identify it. Bumping it
+// when the constraints of the circuits change lets Load refuse stale files without
+// compiling; CheckConstraints catches the changes it misses. TestCircuitVersion detects
+// them.
+const CircuitVersion = 2
+
+var ErrCircuitChanged = errors.New("prover: file belongs to another circuit")
+
+// CircuitHash identifies a circuit definition: the sha256 of CircuitVersion, the
+// configuration of the circuit and the schema of its witness. Unlike the ConstraintHash,
+// it is known without compiling the circuit.
+type CircuitHash [32]byte
+
+func (h CircuitHash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// ConstraintHash is the sha256 of a serialized constraint system.
+type ConstraintHash [32]byte
+
+func (h ConstraintHash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// HashConstraints returns the ConstraintHash of ccs.
+func HashConstraints(ccs constraint.ConstraintSystem) (ConstraintHash, error) {
+	var h ConstraintHash
+	d := sha256.New()
+	if _, err := ccs.WriteTo(d); err != nil {
+		return h, err
+	}
+	copy(h[:], d.Sum(nil))
+	return h, nil
+}
+
+// Hash returns the CircuitHash of the circuit inserting nbBatches batches of configuration
+// c at once: SubtreeUpdateCircuit for one, MultiSubtreeUpdateCircuit for more.
+func Hash(c circuit.Config, nbBatches int) (CircuitHash, error) {
+	var h CircuitHash
+	assignment, err := newCircuit(c, nbBatches)
+	if err != nil {
+		return h, err
+	}
+	s, err := frontend.NewSchema(assignment)
+	if err != nil {
+		return h, err
+	}
+	d := sha256.New()
+	fmt.Fprintf(d, "subtreeUpdate %d\n%s\n", CircuitVersion, c)
+	if nbBatches > 1 {
+		fmt.Fprintf(d, "%d batches\n", nbBatches)
+	}
+	if err := json.NewEncoder(d).Encode(s); err != nil {
+		return h, err
+	}
+	copy(h[:], d.Sum(nil))
+	return h, nil
+}
+
+// Keys are the constraint system of a circuit and its groth16 keys.
+type Keys struct {
+	Hash CircuitHash
+	// Constraints is the hash of CCS, set by Load and Save.
+	Constraints ConstraintHash
+	// NbBatches is the number of batches the circuit inserts at once, 1 if zero.
+	NbBatches int
+	CCS       constraint.ConstraintSystem
+	PK        groth16.ProvingKey
+	VK        groth16.VerifyingKey
+}
+
+// files returns the paths of the R1CS, proving key and verifying key of k in dir.
+func (k *Keys) files(dir string) []string {
+	var res []string
+	for _, name := range []string{R1CSFile, ProvingKeyFile, VerifyingKeyFile} {
+		res = append(res, filepath.Join(dir, FileName(name, k.NbBatches)))
+	}
+	return res
+}
+
+func (k *Keys) header() fileHeader {
+	return fileHeader{k.Hash, k.Constraints}
+}
+
+// Load reads the constraint system and keys of the circuit inserting nbBatches batches of
+// configuration c from dir, without compiling it. It fails with ErrCircuitChanged if any of
+// the files was written for another circuit or constraint system, and with fs.ErrNotExist if
+// one is missing. Only CheckConstraints detects files of the same CircuitHash whose
+// constraints changed.
+func Load(dir string, c circuit.Config, nbBatches int) (*Keys, error) {
+	h, err := Hash(c, nbBatches)
+	if err != nil {
+		return nil, err
+	}
+	return loadKeys(dir, h, nbBatches)
+}
+
+// LoadOrSetup loads the keys of the circuit inserting nbBatches batches of configuration c
+// from dir, or compiles it and runs an insecure local setup if they are missing. Keys of
+// another circuit are only replaced if force is set, and fail with ErrCircuitChanged
+// otherwise. With force, the circuit is compiled even if the keys load, and they are set up
+// again unless they are for its constraints.
+func LoadOrSetup(dir string, c circuit.Config, nbBatches int, force bool) (*Keys, error) {
+	h, err := Hash(c, nbBatches)
+	if err != nil {
+		return nil, err
+	}
+	return loadOrSetup(dir, h, nbBatches, func() (constraint.ConstraintSystem, error) {
+		return CompileBatches(c, nbBatches)
+	}, force)
+}
+
+func loadOrSetup(dir string, h CircuitHash, nbBatches int, compile func() (constraint.ConstraintSystem, error), force bool) (*Keys, error) {
+	loaded, err := loadKeys(dir, h, nbBatches)
+	switch {
+	case err == nil:
+		if !force {
+			return loaded, nil
+		}
+	case errors.Is(err, ErrCircuitChanged):
+		if !force {
+			return nil, err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	k := &Keys{Hash: h, NbBatches: nbBatches}
+	if k.CCS, err = compile(); err != nil {
+		return nil, err
+	}
+	if loaded != nil {
+		if err := CheckConstraints(loaded, k.CCS); err == nil {
+			return loaded, nil
+		}
+	}
+	if k.PK, k.VK, err = groth16.Setup(k.CCS); err != nil {
+		return nil, err
+	}
+	return k, Save(dir, k, force)
+}
+
+// CheckConstraints fails with ErrCircuitChanged unless k was loaded or saved for the
+// constraint system ccs, a fresh compilation of its circuit.
+func CheckConstraints(k *Keys, ccs constraint.ConstraintSystem) error {
+	h, err := HashConstraints(ccs)
+	if err != nil {
+		return err
+	}
+	if h != k.Constraints {
+		return fmt.Errorf("%w: keys of circuit %s are for constraints %s, it compiles to %s", ErrCircuitChanged, k.Hash, k.Constraints, h)
+	}
+	return nil
+}
+
+func loadKeys(dir string, h CircuitHash, nbBatches int) (*Keys, error) {
+	k := &Keys{Hash: h, NbBatches: nbBatches, CCS: groth16.NewCS(Curve), PK: groth16.NewProvingKey(Curve), VK: groth16.NewVerifyingKey(Curve)}
+	files := k.files(dir)
+	// the R1CS file gives the hash of the constraints the keys must be for, and must have it
+	if err := withFile(files[0], func(f io.Reader) error {
+		hdr, err := readHeader(f, files[0], h)
+		if err != nil {
+			return err
+		}
+		d := sha256.New()
+		tee := io.TeeReader(f, d)
+		if _, err := k.CCS.ReadFrom(tee); err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, tee); err != nil {
+			return err
+		}
+		if !bytes.Equal(d.Sum(nil), hdr.Constraints[:]) {
+			return fmt.Errorf("%w: %s does not hash to its constraints %s", ErrCircuitChanged, files[0], hdr.Constraints)
+		}
+		k.Constraints = hdr.Constraints
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := readHashedFile(files[2], k.header(), k.VK.ReadFrom); err != nil {
+		return nil, err
+	}
+	// checking the subgroup of every point of the proving key takes longer than
+	// compiling; a corrupted key can only produce invalid proofs
+	if err := readHashedFile(files[1], k.header(), k.PK.UnsafeReadFrom); err != nil {
+		return nil, err
+	}
+	if err := CheckKeys(k.CCS, k.PK, k.VK); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Save writes the R1CS of k to dir, and its keys unless they are nil, each prefixed with
+// k.Hash and the hash of k.CCS, which it sets k.Constraints to, and named after k.NbBatches.
+// The files of another circuit or constraint system in dir are only replaced if force is
+// set, and fail with ErrCircuitChanged otherwise.
+func Save(dir string, k *Keys, force bool) error {
+	var err error
+	if k.Constraints, err = HashConstraints(k.CCS); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	files := k.files(dir)
+	if !force {
+		for _, path := range files {
+			if err := checkHashedFile(path, k.header()); err != nil {
+				return err
+			}
+		}
+	}
+	// the proving key is written uncompressed, decompressing its points is slower than
+	// reading twice the bytes
+	values := []io.WriterTo{k.CCS}
+	if k.PK != nil && k.VK != nil {
+		values = append(values, rawWriter{k.PK}, k.VK)
+	}
+	for i, v := range values {
+		if err := WriteFile(files[i], hashedWriter{k.header(), v}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileHeader starts every file of a key directory.
+type fileHeader struct {
+	Circuit     CircuitHash
+	Constraints ConstraintHash
+}
+
+// readHeader reads the header of the file at path from f, and checks that it is for the
+// circuit h.
+func readHeader(f io.Reader, path string, h CircuitHash) (fileHeader, error) {
+	var hdr fileHeader
+	if _, err := io.ReadFull(f, hdr.Circuit[:]); err != nil {
+		return hdr, err
+	}
+	if _, err := io.ReadFull(f, hdr.Constraints[:]); err != nil {
+		return hdr, err
+	}
+	if hdr.Circuit != h {
+		return hdr, fmt.Errorf("%w: %s is for circuit %s, not %s", ErrCircuitChanged, path, hdr.Circuit, h)
+	}
+	return hdr, nil
+}
+
+// checkHashedFile fails with ErrCircuitChanged if the file at path exists and does not
+// start with hdr.
+func checkHashedFile(path string, hdr fileHeader) error {
+	err := readHashedFile(path, hdr, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// ReadVerifyingKeyFile reads a verifying key written by Save, and the hash of its circuit.
+func ReadVerifyingKeyFile(path string) (groth16.VerifyingKey, CircuitHash, error) {
+	vk := groth16.NewVerifyingKey(Curve)
+	var hdr fileHeader
+	err := withFile(path, func(f io.Reader) error {
+		if _, err := io.ReadFull(f, hdr.Circuit[:]); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(f, hdr.Constraints[:]); err != nil {
+			return err
+		}
+		_, err := vk.ReadFrom(f)
+		return err
+	})
+	return vk, hdr.Circuit, err
+}
+
+type hashedWriter struct {
+	hdr fileHeader
+	v   io.WriterTo
+}
+
+func (w hashedWriter) WriteTo(wr io.Writer) (int64, error) {
+	n, err := wr.Write(append(w.hdr.Circuit[:], w.hdr.Constraints[:]...))
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.v.WriteTo(wr)
+	return int64(n) + m, err
+}
+
+type rawWriter struct {
+	v interface {
+		WriteRawTo(io.Writer) (int64, error)
+	}
+}
+
+func (w rawWriter) WriteTo(wr io.Writer) (int64, error) {
+	return w.v.WriteRawTo(wr)
+}
+
+// readHashedFile checks that the file at path starts with hdr, and reads the rest with
+// read if it is not nil.
+func readHashedFile(path string, hdr fileHeader, read func(io.Reader) (int64, error)) error {
+	return withFile(path, func(f io.Reader) error {
+		got, err := readHeader(f, path, hdr.Circuit)
+		if err != nil {
+			return err
+		}
+		if got.Constraints != hdr.Constraints {
+			return fmt.Errorf("%w: %s is for constraints %s, not %s", ErrCircuitChanged, path, got.Constraints, hdr.Constraints)
+		}
+		if read == nil {
+			return nil
+		}
+		_, err = read(f)
+		return err
+	})
+}
+
+func withFile(path string, fn func(io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := fn(f); err != nil {
+		if errors.Is(err, ErrCircuitChanged) {
+			return err
+		}
+		return fmt.Errorf("prover: read %s: %w", path, err)
+	}
+	return nil
+}
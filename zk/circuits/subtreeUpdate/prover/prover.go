@@ -0,0 +1,171 @@
+// Package prover compiles the subtree update circuit and reads and writes the files of
+// the prover tooling: constraint system, keys, proofs and JSON witnesses.
+package prover
+
+import (
+	"errors"
+	"fmt"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/schema"
+	"io"
+	"os"
+	"path/filepath"
+	"subtreeUpdate/circuit"
+)
+
+// Curve is the curve of every key and proof, the one of sui::groth16::bn254.
+const Curve = ecc.BN254
+
+var ErrKeyMismatch = errors.New("prover: keys do not match the constraint system")
+
+// Compile compiles SubtreeUpdateCircuit of circuit.DefaultConfig into a R1CS.
+func Compile() (constraint.ConstraintSystem, error) {
+	return CompileConfig(circuit.DefaultConfig)
+}
+
+// CompileConfig compiles the SubtreeUpdateCircuit of configuration c into a R1CS.
+func CompileConfig(c circuit.Config) (constraint.ConstraintSystem, error) {
+	assignment, err := circuit.NewCircuit(c)
+	if err != nil {
+		return nil, err
+	}
+	ccs, err := frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, assignment)
+	if err != nil {
+		return nil, fmt.Errorf("prover: compile %s: %w", c, err)
+	}
+	return ccs, nil
+}
+
+// CompileMulti compiles the MultiSubtreeUpdateCircuit inserting nbBatches batches of
+// configuration c into a R1CS.
+func CompileMulti(c circuit.Config, nbBatches int) (constraint.ConstraintSystem, error) {
+	assignment, err := circuit.NewMultiCircuit(c, nbBatches)
+	if err != nil {
+		return nil, err
+	}
+	ccs, err := frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, assignment)
+	if err != nil {
+		return nil, fmt.Errorf("prover: compile %d batches of %s: %w", nbBatches, c, err)
+	}
+	return ccs, nil
+}
+
+// CompileBatches compiles the circuit inserting nbBatches batches of configuration c at
+// once into a R1CS: SubtreeUpdateCircuit for one, MultiSubtreeUpdateCircuit for more.
+func CompileBatches(c circuit.Config, nbBatches int) (constraint.ConstraintSystem, error) {
+	if nbBatches == 1 {
+		return CompileConfig(c)
+	}
+	return CompileMulti(c, nbBatches)
+}
+
+// newCircuit returns the circuit of CompileBatches, ready to be compiled.
+func newCircuit(c circuit.Config, nbBatches int) (frontend.Circuit, error) {
+	if nbBatches == 1 {
+		return circuit.NewCircuit(c)
+	}
+	return circuit.NewMultiCircuit(c, nbBatches)
+}
+
+// CheckKeys checks that pk and vk can have been set up for ccs. It only compares sizes,
+// a proof is still needed to detect keys of another circuit of the same shape.
+func CheckKeys(ccs constraint.ConstraintSystem, pk groth16.ProvingKey, vk groth16.VerifyingKey) error {
+	if pk.CurveID() != Curve || vk.CurveID() != Curve {
+		return fmt.Errorf("%w: keys are not on %s", ErrKeyMismatch, Curve)
+	}
+	if vk.NbPublicWitness() != ccs.GetNbPublicVariables()-1 {
+		return fmt.Errorf("%w: verifying key has %d public inputs, circuit %d", ErrKeyMismatch, vk.NbPublicWitness(), ccs.GetNbPublicVariables()-1)
+	}
+	return nil
+}
+
+// WriteFile writes v to path, through a temporary file so that path is either left
+// untouched or completely written.
+func WriteFile(path string, v io.WriterTo) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := v.WriteTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("prover: write %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func readFile(path string, v io.ReaderFrom) error {
+	return withFile(path, func(f io.Reader) error {
+		_, err := v.ReadFrom(f)
+		return err
+	})
+}
+
+// ReadProvingKey reads a proving key in gnark's encoding, such as the output of an
+// external setup.
+func ReadProvingKey(path string) (groth16.ProvingKey, error) {
+	pk := groth16.NewProvingKey(Curve)
+	return pk, readFile(path, pk)
+}
+
+// ReadVerifyingKey reads a verifying key in gnark's encoding.
+func ReadVerifyingKey(path string) (groth16.VerifyingKey, error) {
+	vk := groth16.NewVerifyingKey(Curve)
+	return vk, readFile(path, vk)
+}
+
+func ReadProof(path string) (groth16.Proof, error) {
+	proof := groth16.NewProof(Curve)
+	return proof, readFile(path, proof)
+}
+
+// MarshalWitness encodes a full or public witness of the circuit inserting nbBatches
+// batches of configuration c as JSON, keyed by the gnark tags of the circuit.
+func MarshalWitness(c circuit.Config, nbBatches int, w witness.Witness) ([]byte, error) {
+	s, err := witnessSchema(c, nbBatches)
+	if err != nil {
+		return nil, err
+	}
+	return w.ToJSON(s)
+}
+
+// UnmarshalWitness decodes a witness encoded by MarshalWitness. The witness is public if
+// data has no private values.
+func UnmarshalWitness(c circuit.Config, nbBatches int, data []byte) (witness.Witness, error) {
+	s, err := witnessSchema(c, nbBatches)
+	if err != nil {
+		return nil, err
+	}
+	w, err := witness.New(Curve.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	if err := w.FromJSON(s, data); err != nil {
+		return nil, fmt.Errorf("prover: witness: %w", err)
+	}
+	return w, nil
+}
+
+func witnessSchema(c circuit.Config, nbBatches int) (*schema.Schema, error) {
+	assignment, err := newCircuit(c, nbBatches)
+	if err != nil {
+		return nil, err
+	}
+	return frontend.NewSchema(assignment)
+}
+
+func ReadWitness(c circuit.Config, nbBatches int, path string) (witness.Witness, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalWitness(c, nbBatches, data)
+}
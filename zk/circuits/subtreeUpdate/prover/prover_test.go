@@ -0,0 +1,346 @@
+package prover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"subtreeUpdate/circuit"
+	"subtreeUpdate/tree"
+	"testing"
+)
+
+func noteHashes(seed uint64) [][32]byte {
+	res := make([][32]byte, tree.BatchSize)
+	for i := range res {
+		res[i] = sha256.Sum256(binary.BigEndian.AppendUint64(nil, seed*tree.BatchSize+uint64(i)))
+	}
+	return res
+}
+
+func TestWitnessJSONRoundTrip(t *testing.T) {
+	w, err := circuit.BuildWitness(tree.New(), noteHashes(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi, err := circuit.BuildMultiWitness(tree.New(), [][][32]byte{noteHashes(0), noteHashes(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		name      string
+		nbBatches int
+		in        witness.Witness
+		public    witness.Witness
+	}{
+		{"full", 1, w.Full, w.Public},
+		{"public", 1, w.Public, w.Public},
+		{"2 batches", 2, multi.Full, multi.Public},
+	} {
+		data, err := MarshalWitness(circuit.DefaultConfig, c.nbBatches, c.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := UnmarshalWitness(circuit.DefaultConfig, c.nbBatches, data)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		want, _ := c.in.MarshalBinary()
+		got, _ := out.MarshalBinary()
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s witness changed by the JSON round trip", c.name)
+		}
+		public, err := out.Public()
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotPub, wantPub := public.Vector().(fr.Vector), c.public.Vector().(fr.Vector)
+		if len(gotPub) != len(wantPub) {
+			t.Fatalf("%s: %d public inputs, want %d", c.name, len(gotPub), len(wantPub))
+		}
+		for i := range wantPub {
+			if !gotPub[i].Equal(&wantPub[i]) {
+				t.Fatalf("%s: public input %d is %s, want %s", c.name, i, gotPub[i].String(), wantPub[i].String())
+			}
+		}
+	}
+
+	if _, err := UnmarshalWitness(circuit.DefaultConfig, 1, []byte(`{"oldRoot": "1"}`)); err == nil {
+		t.Fatal("incomplete witness was accepted")
+	}
+}
+
+type onePublicCircuit struct {
+	X frontend.Variable `gnark:",public"`
+	Y frontend.Variable
+}
+
+func (c *onePublicCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.Y, c.Y), c.X)
+	return nil
+}
+
+func compileOnePublic() (constraint.ConstraintSystem, error) {
+	return frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, &onePublicCircuit{})
+}
+
+// cubeCircuit has the witness of onePublicCircuit, and other constraints.
+type cubeCircuit onePublicCircuit
+
+func (c *cubeCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.Y, c.Y, c.Y), c.X)
+	return nil
+}
+
+func compileCube() (constraint.ConstraintSystem, error) {
+	return frontend.Compile(Curve.ScalarField(), r1cs.NewBuilder, &cubeCircuit{})
+}
+
+func hashConstraints(t *testing.T, ccs constraint.ConstraintSystem) string {
+	h, err := HashConstraints(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.String()
+}
+
+func TestKeysRoundTrip(t *testing.T) {
+	h := CircuitHash{1}
+	dir := t.TempDir()
+	if _, err := loadKeys(dir, h, 1); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v, want %v", err, fs.ErrNotExist)
+	}
+	k, err := loadOrSetup(dir, h, 1, compileOnePublic, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadOrSetup(dir, h, 1, func() (constraint.ConstraintSystem, error) {
+		t.Fatal("circuit compiled again")
+		return nil, nil
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.PK.IsDifferent(k.PK) || loaded.VK.IsDifferent(k.VK) {
+		t.Fatal("keys were set up again instead of loaded")
+	}
+	if hashConstraints(t, loaded.CCS) != hashConstraints(t, k.CCS) {
+		t.Fatal("constraint system changed by its file")
+	}
+	vk, vkHash, err := ReadVerifyingKeyFile(filepath.Join(dir, VerifyingKeyFile))
+	if err != nil || vkHash != h || vk.IsDifferent(k.VK) {
+		t.Fatalf("verifying key file: %v", err)
+	}
+
+	// the loaded constraint system proves
+	w, err := frontend.NewWitness(&onePublicCircuit{X: 9, Y: 3}, Curve.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := w.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := groth16.Prove(loaded.CCS, loaded.PK, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := groth16.Verify(proof, loaded.VK, public); err != nil {
+		t.Fatal(err)
+	}
+
+	// forcing checks the constraints of the keys against a fresh compilation, and sets
+	// them up again if they changed under the same CircuitHash
+	if loaded, err = loadOrSetup(dir, h, 1, compileOnePublic, true); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.PK.IsDifferent(k.PK) || loaded.VK.IsDifferent(k.VK) {
+		t.Fatal("keys of unchanged constraints were set up again")
+	}
+	cube, err := compileCube()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckConstraints(loaded, cube); !errors.Is(err, ErrCircuitChanged) {
+		t.Fatalf("got %v, want %v", err, ErrCircuitChanged)
+	}
+	if err := Save(dir, &Keys{Hash: h, CCS: cube}, false); !errors.Is(err, ErrCircuitChanged) {
+		t.Fatalf("got %v, want %v", err, ErrCircuitChanged)
+	}
+	cubeDir := t.TempDir()
+	if err := Save(cubeDir, &Keys{Hash: h, CCS: k.CCS, PK: k.PK, VK: k.VK}, false); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = loadOrSetup(cubeDir, h, 1, compileCube, true); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.VK.IsDifferent(k.VK) || CheckConstraints(loaded, cube) != nil {
+		t.Fatal("keys of changed constraints not set up again")
+	}
+	if _, err := loadKeys(cubeDir, h, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// an R1CS file that does not hash to its header is refused
+	r1csPath := filepath.Join(cubeDir, R1CSFile)
+	data, err := os.ReadFile(r1csPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if err := os.WriteFile(r1csPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadKeys(cubeDir, h, 1); !errors.Is(err, ErrCircuitChanged) {
+		t.Fatalf("got %v, want %v", err, ErrCircuitChanged)
+	}
+
+	// keys of a modified circuit are refused, and only replaced when forced
+	changed := CircuitHash{2}
+	if _, err := loadKeys(dir, changed, 1); !errors.Is(err, ErrCircuitChanged) {
+		t.Fatalf("got %v, want %v", err, ErrCircuitChanged)
+	}
+	if _, err := loadOrSetup(dir, changed, 1, compileOnePublic, false); !errors.Is(err, ErrCircuitChanged) {
+		t.Fatalf("got %v, want %v", err, ErrCircuitChanged)
+	}
+	if err := Save(dir, &Keys{Hash: changed, CCS: k.CCS, PK: k.PK, VK: k.VK}, false); !errors.Is(err, ErrCircuitChanged) {
+		t.Fatalf("got %v, want %v", err, ErrCircuitChanged)
+	}
+	if _, err := loadKeys(dir, h, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadOrSetup(dir, changed, 1, compileOnePublic, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadKeys(dir, changed, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadKeys(dir, h, 1); !errors.Is(err, ErrCircuitChanged) {
+		t.Fatalf("got %v, want %v", err, ErrCircuitChanged)
+	}
+
+	// the keys of several batches have files of their own
+	if _, err := loadOrSetup(dir, h, 4, compileOnePublic, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadKeys(dir, changed, 1); err != nil {
+		t.Fatal(err)
+	}
+	if name := FileName(VerifyingKeyFile, 4); name != "subtreeUpdate4.vk" {
+		t.Fatalf("verifying key of 4 batches in %s", name)
+	}
+	if _, vkHash, err := ReadVerifyingKeyFile(filepath.Join(dir, "subtreeUpdate4.vk")); err != nil || vkHash != h {
+		t.Fatalf("verifying key file of 4 batches: %v", err)
+	}
+}
+
+// constraintHashes are the ConstraintHash of the circuits of circuit.Configs, and of
+// circuit.DefaultConfig inserting several batches, when CircuitVersion was last bumped.
+var constraintHashes = []struct {
+	config    circuit.Config
+	nbBatches int
+	hash      string
+}{
+	{circuit.Configs[0], 1, "24b8b68dc689ef543e5afb14cbcc22a49177efb5b7b0519f1abd5a873b0af0ff"},
+	{circuit.Configs[1], 1, "67a9eeb36a0e58dd35f813062b648f8202cee35bb2f8d67b01525f64f77b155d"},
+	{circuit.Configs[2], 1, "55fc1be61e94823a84819e01fe06be80a87e2acc25662e02d49ac8fd31964f6a"},
+	{circuit.Configs[3], 1, "9e3135ad51bbd4a113385e4f248f3bf07638e7dee335d424777671eda8c98886"},
+	{circuit.Configs[4], 1, "900a61061df587fcef1e6390554f4f68c9d5adfe9b5aa5c054580dc9292ae72e"},
+	{circuit.Configs[5], 1, "c0f6c6f4569cd643d06265497f1b756c57ad42d037fea5be6a8662d93a34ce03"},
+	{circuit.Configs[6], 1, "8cac8749ae9ab1b2ce023400eaf87d2006c0a0b558093c94c7145ebcb5488c76"},
+	{circuit.Configs[7], 1, "90e3c3fc845e7f2d8ffd6bbc39bb274520e8f2baaaa3e014a019d16731f265bd"},
+	{circuit.DefaultConfig, 2, "19ace60f4526d529aecc34cc515e1e3422ac8d3fefe525166cd86728ffefb175"},
+	{circuit.DefaultConfig, 4, "94e56af364979992fd1aed0db3be57f8ca9dc8bd6b2915439b5e57d1438f3771"},
+}
+
+// TestCircuitVersion fails when the constraints of a circuit change without
+// CircuitVersion, and when two circuits share a CircuitHash.
+func TestCircuitVersion(t *testing.T) {
+	if len(constraintHashes) != len(circuit.Configs)+2 {
+		t.Fatal("constraintHashes does not cover circuit.Configs")
+	}
+	// the largest circuits are only compiled within 4GiB if collected eagerly, and their
+	// heap is returned before the next tests
+	defer debug.SetMemoryLimit(debug.SetMemoryLimit(4 << 30))
+	defer debug.FreeOSMemory()
+	seen := map[CircuitHash]int{}
+	for i, test := range constraintHashes {
+		ccs, err := CompileBatches(test.config, test.nbBatches)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hashConstraints(t, ccs); got != test.hash {
+			t.Errorf("%s, %d batches: R1CS hash %s, want %s: bump CircuitVersion and update constraintHashes", test.config, test.nbBatches, got, test.hash)
+		}
+		h, err := Hash(test.config, test.nbBatches)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j, ok := seen[h]; ok {
+			t.Errorf("circuits %d and %d of constraintHashes have the same hash", j, i)
+		}
+		seen[h] = i
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	ccs, err := compileOnePublic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckKeys(ccs, pk, vk); err != nil {
+		t.Fatal(err)
+	}
+	// keys of a circuit with another number of public inputs
+	full, err := Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckKeys(full, pk, vk); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrKeyMismatch)
+	}
+}
+
+// BenchmarkAccumulators compares the sha256 and Poseidon accumulators on the circuit of
+// circuit.DefaultConfig: number of constraints and groth16 proving time. The setup of
+// each circuit is not timed.
+func BenchmarkAccumulators(b *testing.B) {
+	for _, acc := range []circuit.Accumulator{circuit.SHA256, circuit.Poseidon} {
+		c := circuit.DefaultConfig
+		c.Accumulator = acc
+		ccs, err := CompileConfig(c)
+		if err != nil {
+			b.Fatal(err)
+		}
+		pk, _, err := groth16.Setup(ccs)
+		if err != nil {
+			b.Fatal(err)
+		}
+		w, err := c.BuildWitness(tree.New(), noteHashes(0))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(acc.String(), func(b *testing.B) {
+			b.ReportMetric(float64(ccs.GetNbConstraints()), "constraints")
+			for i := 0; i < b.N; i++ {
+				if _, err := groth16.Prove(ccs, pk, w.Full); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
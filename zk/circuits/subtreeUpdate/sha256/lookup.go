@@ -0,0 +1,198 @@
+package sha256
+
+import (
+	"errors"
+	"fmt"
+	"github.com/consensys/gnark/backend/hint"
+	"github.com/consensys/gnark/frontend"
+	"math/big"
+)
+
+func init() {
+	hint.Register(multiplicitiesHint, splitHint, nibbleOpHint, addHint)
+}
+
+// Tags of the sub-tables of lookupTable. An entry packs its fields, nibbles or small
+// values, 4 bits each from the least significant, under the tag.
+const (
+	tagNibble = iota
+	tagCarry
+	tagSplit1
+	tagSplit2
+	tagSplit3
+	tagXor3
+	tagCh
+	tagMaj
+)
+
+const tagShift = 16
+
+// maxCarry bounds the carry of the sum of 8 words, more than blockLookup adds at once.
+const maxCarry = 8
+
+// nibbleOps are the boolean functions of three nibbles of the xor3, ch and maj tables.
+var nibbleOps = map[int]func(x, y, z uint64) uint64{
+	tagXor3: func(x, y, z uint64) uint64 { return x ^ y ^ z },
+	tagCh:   func(x, y, z uint64) uint64 { return (x & y) ^ (^x & z & 0xf) },
+	tagMaj:  func(x, y, z uint64) uint64 { return (x & y) ^ (x & z) ^ (y & z) },
+}
+
+func pack(tag int, fields ...uint64) uint64 {
+	res := uint64(tag) << tagShift
+	for i, f := range fields {
+		res |= f << (4 * i)
+	}
+	return res
+}
+
+// lookupTable lists every entry of the table of the lookup argument, and lookupIndex the
+// position of each:
+//   - nibbles, and carries below maxCarry
+//   - splits of a nibble n into n >> s and its low s bits, for s of 1 to 3
+//   - xor3, ch and maj of every three nibbles, 4096 entries each.
+var lookupTable, lookupIndex = func() ([]uint64, map[uint64]int) {
+	var table []uint64
+	for n := uint64(0); n < 16; n++ {
+		table = append(table, pack(tagNibble, n))
+	}
+	for c := uint64(0); c < maxCarry; c++ {
+		table = append(table, pack(tagCarry, c))
+	}
+	for s := 1; s <= 3; s++ {
+		for n := uint64(0); n < 16; n++ {
+			table = append(table, pack(tagSplit1+s-1, n, n>>s, n&(1<<s-1)))
+		}
+	}
+	for _, tag := range []int{tagXor3, tagCh, tagMaj} {
+		for i := uint64(0); i < 16*16*16; i++ {
+			x, y, z := i&0xf, i>>4&0xf, i>>8
+			table = append(table, pack(tag, x, y, z, nibbleOps[tag](x, y, z)))
+		}
+	}
+	index := make(map[uint64]int, len(table))
+	for i, e := range table {
+		index[e] = i
+	}
+	return table, index
+}()
+
+// logDerivLookup is a log-derivative lookup argument (https://eprint.iacr.org/2022/1530)
+// into lookupTable: the queries are entries of the table if
+//
+//	sum_q 1/(r-q) = sum_t m_t/(r-t)
+//
+// at a random r, m_t being the number of queries of entry t. r is derived from a commitment
+// to the queries and the multiplicities, with the experimental Commit of gnark: only groth16
+// proves it, and a circuit holds a single commitment, so a single argument.
+type logDerivLookup struct {
+	api       frontend.API
+	queries   []frontend.Variable
+	committed bool
+}
+
+// errCommitted is the error of a lookup after the argument was committed.
+var errCommitted = errors.New("sha256: lookup after the argument was committed")
+
+// query adds the constraint that the entry of tag and fields is in the table. It fails once
+// the argument is committed.
+func (l *logDerivLookup) query(tag int, fields ...frontend.Variable) error {
+	if l.committed {
+		return errCommitted
+	}
+	q := frontend.Variable(uint64(tag) << tagShift)
+	for i, f := range fields {
+		q = l.api.Add(q, l.api.Mul(f, 1<<(4*i)))
+	}
+	l.queries = append(l.queries, q)
+	return nil
+}
+
+// commit adds the constraints of the argument. No query can be added after.
+func (l *logDerivLookup) commit() error {
+	if l.committed {
+		return errCommitted
+	}
+	l.committed = true
+	if len(l.queries) == 0 {
+		return nil
+	}
+	m, err := l.api.Compiler().NewHint(multiplicitiesHint, len(lookupTable), l.queries...)
+	if err != nil {
+		return err
+	}
+	r, err := l.api.Compiler().Commit(append(append([]frontend.Variable{}, l.queries...), m...)...)
+	if err != nil {
+		return fmt.Errorf("sha256: lookup commitment: %w", err)
+	}
+	// the sums are added at once, adding the terms one by one is quadratic
+	lhs := make([]frontend.Variable, len(l.queries))
+	for i, q := range l.queries {
+		lhs[i] = l.api.Inverse(l.api.Sub(r, q))
+	}
+	rhs := make([]frontend.Variable, len(lookupTable))
+	for i, t := range lookupTable {
+		rhs[i] = l.api.DivUnchecked(m[i], l.api.Sub(r, t))
+	}
+	l.api.AssertIsEqual(sum(l.api, lhs), sum(l.api, rhs))
+	return nil
+}
+
+func sum(api frontend.API, in []frontend.Variable) frontend.Variable {
+	switch len(in) {
+	case 1:
+		return in[0]
+	default:
+		return api.Add(in[0], in[1], in[2:]...)
+	}
+}
+
+// multiplicitiesHint counts the queries of every entry of lookupTable.
+func multiplicitiesHint(_ *big.Int, in []*big.Int, out []*big.Int) error {
+	for _, o := range out {
+		o.SetUint64(0)
+	}
+	for _, q := range in {
+		if !q.IsUint64() {
+			return fmt.Errorf("sha256: lookup of %s, not in the table", q)
+		}
+		i, ok := lookupIndex[q.Uint64()]
+		if !ok {
+			return fmt.Errorf("sha256: lookup of %s, not in the table", q)
+		}
+		out[i].Add(out[i], big.NewInt(1))
+	}
+	return nil
+}
+
+// splitHint splits in[1:] into their values shifted right by in[0] bits, then their low
+// in[0] bits.
+func splitHint(_ *big.Int, in []*big.Int, out []*big.Int) error {
+	s := uint(in[0].Uint64())
+	values := in[1:]
+	for i, v := range values {
+		out[i].Rsh(v, s)
+		out[len(values)+i].And(v, big.NewInt(1<<s-1))
+	}
+	return nil
+}
+
+// nibbleOpHint applies the function of tag in[0] to the three words of nibbles of in[1:].
+func nibbleOpHint(_ *big.Int, in []*big.Int, out []*big.Int) error {
+	op := nibbleOps[int(in[0].Uint64())]
+	n := len(out)
+	for i := range out {
+		out[i].SetUint64(op(in[1+i].Uint64(), in[1+n+i].Uint64(), in[1+2*n+i].Uint64()))
+	}
+	return nil
+}
+
+// addHint decomposes in[0] into 8 nibbles of its low 32 bits and the carry above them.
+func addHint(_ *big.Int, in []*big.Int, out []*big.Int) error {
+	v := new(big.Int).Set(in[0])
+	for i := 0; i < 8; i++ {
+		out[i].And(v, big.NewInt(0xf))
+		v.Rsh(v, 4)
+	}
+	out[8].Set(v)
+	return nil
+}
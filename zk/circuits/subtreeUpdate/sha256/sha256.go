@@ -2,7 +2,6 @@ package sha256
 
 import (
 	"fmt"
-	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/frontend"
 )
 
@@ -19,16 +18,21 @@ var (
 	init7 = constUint32(0x5BE0CD19)
 )
 
-type digest struct {
+// Digest is the sha256 gadget, hashing bytes bit by bit. Its zero value is not usable, see
+// New.
+type Digest struct {
 	h   [8]xuint32
 	x   [chunk]xuint8 // 64 byte
 	nx  int
 	len uint64
-	id  ecc.ID
 	api frontend.API
+	err error // first error of the compression function, returned by Sum
+	// reduceRounds reduces t1 and t2 modulo 2^32 before adding them, as blockGeneric did
+	// before roundHint. Only the benchmarks set it, to compare both.
+	reduceRounds bool
 }
 
-func (d *digest) Reset() {
+func (d *Digest) Reset() {
 	d.h[0] = init0
 	d.h[1] = init1
 	d.h[2] = init2
@@ -40,38 +44,35 @@ func (d *digest) Reset() {
 
 	d.nx = 0
 	d.len = 0
+	d.err = nil
 }
 
-func New(api frontend.API) digest {
-	res := digest{}
-	res.id = ecc.BN254
-	res.api = api
-	res.nx = 0
-	res.len = 0
+func New(api frontend.API) *Digest {
+	res := &Digest{api: api}
 	res.Reset()
 	return res
 }
 
-// p: byte array
-func (d *digest) Write(p []frontend.Variable) (nn int, err error) {
+// Write adds the bytes p to the preimage, constraining them to be bytes.
+func (d *Digest) Write(p ...frontend.Variable) {
 
 	var in []xuint8
 	for i := range p {
 		in = append(in, newUint8API(d.api).asUint8(p[i]))
 	}
-	return d.write(in)
+	d.write(in)
 
 }
 
-func (d *digest) write(p []xuint8) (nn int, err error) {
-	nn = len(p)
+func (d *Digest) write(p []xuint8) {
+	nn := len(p)
 	d.len += uint64(nn)
 
 	if d.nx > 0 {
 		n := copy(d.x[d.nx:], p)
 		d.nx += n
 		if d.nx == chunk {
-			blockGeneric(d, d.x[:])
+			d.block(d.x[:])
 			d.nx = 0
 		}
 		p = p[n:]
@@ -79,26 +80,35 @@ func (d *digest) write(p []xuint8) (nn int, err error) {
 
 	if len(p) >= chunk {
 		n := len(p) &^ (chunk - 1)
-		blockGeneric(d, p[:n])
+		d.block(p[:n])
 		p = p[n:]
 	}
 
 	if len(p) > 0 {
 		d.nx = copy(d.x[:], p)
 	}
+}
 
-	return
+func (d *Digest) block(p []xuint8) {
+	if err := blockGeneric(d, p); err != nil && d.err == nil {
+		d.err = err
+	}
 }
 
-func (d *digest) Sum() []frontend.Variable {
+// Sum returns the 32 bytes of the hash of the preimage written so far. It does not change
+// the preimage: more bytes can be written after.
+func (d *Digest) Sum() ([]frontend.Variable, error) {
 
 	d0 := *d
-	hash := d0.checkSum()
+	hash, err := d0.checkSum()
+	if err != nil {
+		return nil, err
+	}
 
-	return hash[:]
+	return hash[:], nil
 }
 
-func (d *digest) checkSum() []frontend.Variable {
+func (d *Digest) checkSum() ([]frontend.Variable, error) {
 	// Padding
 	len := d.len
 	var tmp [64]xuint8
@@ -116,12 +126,18 @@ func (d *digest) checkSum() []frontend.Variable {
 	len <<= 3
 	PutUint64(d.api, tmp[:], newUint64API(d.api).asUint64(len))
 	d.write(tmp[0:8])
-	fmt.Printf("block number:%d\n", d.len/64)
 
+	if d.err != nil {
+		return nil, d.err
+	}
 	if d.nx != 0 {
-		panic("d.nx != 0")
+		return nil, fmt.Errorf("sha256: %d bytes left after the padding", d.nx)
 	}
+	return d.bytes(), nil
+}
 
+// bytes returns the 32 bytes of the state of d.
+func (d *Digest) bytes() []frontend.Variable {
 	var digest [32]xuint8
 
 	// h[0]..h[7]
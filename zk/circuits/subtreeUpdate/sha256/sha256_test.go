@@ -0,0 +1,356 @@
+package sha256
+
+import (
+	"crypto/sha256"
+	"errors"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/test"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// digest is the API shared by Digest and LookupDigest.
+type digest interface {
+	Write(data ...frontend.Variable)
+	Sum() ([]frontend.Variable, error)
+}
+
+type sha256Circuit struct {
+	Preimage []frontend.Variable
+	Hash     [32]frontend.Variable `gnark:",public"`
+	lookup   bool
+	reduced  bool
+	// writes are the lengths of the first writes of the preimage, the rest written at once
+	writes []int
+}
+
+func (c *sha256Circuit) Define(api frontend.API) error {
+	var d digest = New(api)
+	if c.lookup {
+		d = NewLookup(api)
+	} else if c.reduced {
+		d = newReduced(api)
+	}
+	p := c.Preimage
+	for _, n := range c.writes {
+		d.Write(p[:n]...)
+		p = p[n:]
+	}
+	d.Write(p...)
+	sum, err := d.Sum()
+	if err != nil {
+		return err
+	}
+	for i := range c.Hash {
+		api.AssertIsEqual(sum[i], c.Hash[i])
+	}
+	return nil
+}
+
+// newReduced is New with the round terms reduced as before roundHint.
+func newReduced(api frontend.API) *Digest {
+	d := New(api)
+	d.reduceRounds = true
+	return d
+}
+
+func preimage(n int) []byte {
+	res := make([]byte, n)
+	for i := range res {
+		res[i] = byte(7*i + 3)
+	}
+	return res
+}
+
+func assignment(preimage []byte, hash [32]byte) *sha256Circuit {
+	res := &sha256Circuit{Preimage: make([]frontend.Variable, len(preimage))}
+	for i, b := range preimage {
+		res.Preimage[i] = b
+	}
+	for i, b := range hash {
+		res.Hash[i] = b
+	}
+	return res
+}
+
+type setup struct {
+	ccs constraint.ConstraintSystem
+	pk  groth16.ProvingKey
+	vk  groth16.VerifyingKey
+}
+
+func newSetup(tb testing.TB, n int, lookup bool) setup {
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &sha256Circuit{Preimage: make([]frontend.Variable, n), lookup: lookup})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return setup{ccs, pk, vk}
+}
+
+// prove proves the hash of preimage, the test engine not supporting the commitment of the
+// lookup argument.
+func (s setup) prove(preimage []byte, hash [32]byte) error {
+	w, err := frontend.NewWitness(assignment(preimage, hash), ecc.BN254.ScalarField())
+	if err != nil {
+		return err
+	}
+	proof, err := groth16.Prove(s.ccs, s.pk, w)
+	if err != nil {
+		return err
+	}
+	public, err := w.Public()
+	if err != nil {
+		return err
+	}
+	return groth16.Verify(proof, s.vk, public)
+}
+
+// isSolved checks the hash of preimage against New, written in parts of the lengths writes.
+func isSolved(preimage []byte, hash [32]byte, writes ...int) error {
+	circuit := &sha256Circuit{Preimage: make([]frontend.Variable, len(preimage)), writes: writes}
+	return test.IsSolved(circuit, assignment(preimage, hash), ecc.BN254.ScalarField())
+}
+
+func TestSum(t *testing.T) {
+	for _, c := range []struct {
+		n      int
+		writes []int
+	}{
+		{0, nil},
+		{1, nil},
+		// the padding boundaries: 55 bytes is the longest message padded in its last block,
+		// 56 to 63 the ones spilling the length into another block
+		{55, nil},
+		{56, nil},
+		{63, nil},
+		{64, nil},
+		{119, nil},
+		{120, nil},
+		{200, nil},
+		// writes filling the buffered block in parts, and whole blocks with a rest
+		{100, []int{10, 60}},
+		{150, []int{64, 0, 3}},
+		{130, []int{1, 1, 1}},
+	} {
+		p := preimage(c.n)
+		if err := isSolved(p, sha256.Sum256(p), c.writes...); err != nil {
+			t.Fatalf("%d bytes, writes %v: %v", c.n, c.writes, err)
+		}
+		wrong := sha256.Sum256(p)
+		wrong[31] ^= 1
+		if err := isSolved(p, wrong, c.writes...); err == nil {
+			t.Fatalf("%d bytes, writes %v: wrong digest accepted", c.n, c.writes)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		p := make([]byte, rng.Intn(200))
+		rng.Read(p)
+		if err := isSolved(p, sha256.Sum256(p), rng.Intn(len(p)+1)); err != nil {
+			t.Fatalf("%x: %v", p, err)
+		}
+	}
+
+	// the preimage is constrained to bytes: 256 would hash as 0 if it was cut to 8 bits
+	p := preimage(3)
+	a := assignment(p, sha256.Sum256(append([]byte{0}, p[1:]...)))
+	a.Preimage[0] = 256
+	if err := test.IsSolved(&sha256Circuit{Preimage: make([]frontend.Variable, 3)}, a, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("preimage of a value above a byte accepted")
+	}
+}
+
+func FuzzSum(f *testing.F) {
+	for _, n := range []int{0, 55, 56, 64} {
+		f.Add(preimage(n), uint8(0))
+	}
+	f.Add([]byte("abc"), uint8(2))
+	f.Fuzz(func(t *testing.T, p []byte, split uint8) {
+		if len(p) > 200 {
+			p = p[:200]
+		}
+		var writes []int
+		if int(split) <= len(p) {
+			writes = []int{int(split)}
+		}
+		if err := isSolved(p, sha256.Sum256(p), writes...); err != nil {
+			t.Fatalf("%x, split at %d: %v", p, split, err)
+		}
+		if len(p) > 0 {
+			other := append([]byte{}, p...)
+			other[len(p)/2] ^= 0x10
+			if err := isSolved(p, sha256.Sum256(other), writes...); err == nil {
+				t.Fatalf("%x: digest of %x accepted", p, other)
+			}
+		}
+	})
+}
+
+func TestLookup(t *testing.T) {
+	// one block, and two with the length in the second
+	for _, n := range []int{3, 60} {
+		s := newSetup(t, n, true)
+		p := preimage(n)
+		if err := s.prove(p, sha256.Sum256(p)); err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if err := s.prove(p, sha256.Sum256(append(p[1:], 0))); err == nil {
+			t.Fatalf("%d bytes: wrong hash accepted", n)
+		}
+	}
+}
+
+func TestLookupHints(t *testing.T) {
+	out := make([]*big.Int, len(lookupTable))
+	for i := range out {
+		out[i] = new(big.Int)
+	}
+	in := []*big.Int{big.NewInt(int64(pack(tagXor3, 1, 2, 4, 7))), big.NewInt(int64(pack(tagNibble, 9))), big.NewInt(int64(pack(tagXor3, 1, 2, 4, 7)))}
+	if err := multiplicitiesHint(nil, in, out); err != nil {
+		t.Fatal(err)
+	}
+	if m := out[lookupIndex[pack(tagXor3, 1, 2, 4, 7)]]; m.Int64() != 2 {
+		t.Fatalf("multiplicity %s, want 2", m)
+	}
+	// a nibble op with a wrong result is not in the table
+	for _, q := range []uint64{pack(tagXor3, 1, 2, 4, 6), pack(tagNibble, 16), pack(tagCarry, maxCarry)} {
+		if err := multiplicitiesHint(nil, []*big.Int{new(big.Int).SetUint64(q)}, out); err == nil {
+			t.Fatalf("lookup of %x accepted", q)
+		}
+	}
+}
+
+type sumTwiceCircuit struct {
+	Preimage [3]frontend.Variable
+}
+
+func (c *sumTwiceCircuit) Define(api frontend.API) error {
+	d := NewLookup(api)
+	d.Write(c.Preimage[:]...)
+	if _, err := d.Sum(); err != nil {
+		return err
+	}
+	_, err := d.Sum()
+	return err
+}
+
+func TestLookupSumTwice(t *testing.T) {
+	_, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &sumTwiceCircuit{})
+	if !errors.Is(err, errCommitted) {
+		t.Fatalf("got %v, want %v", err, errCommitted)
+	}
+}
+
+type variableCircuit struct {
+	Data   []frontend.Variable
+	Length frontend.Variable
+	Hash   [32]frontend.Variable `gnark:",public"`
+}
+
+func (c *variableCircuit) Define(api frontend.API) error {
+	sum, err := SumVariable(api, c.Data, c.Length)
+	if err != nil {
+		return err
+	}
+	for i := range c.Hash {
+		api.AssertIsEqual(sum[i], c.Hash[i])
+	}
+	return nil
+}
+
+func TestSumVariable(t *testing.T) {
+	// up to 3 blocks, the length taking a block of its own from 120 bytes on
+	const maxLen = 120
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &variableCircuit{Data: make([]frontend.Variable, maxLen)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := preimage(maxLen)
+	solve := func(length int, hash [32]byte) error {
+		assignment := &variableCircuit{Data: make([]frontend.Variable, maxLen), Length: length}
+		for i, b := range data {
+			assignment.Data[i] = b
+		}
+		for i, b := range hash {
+			assignment.Hash[i] = b
+		}
+		w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+		if err != nil {
+			return err
+		}
+		return ccs.IsSolved(w)
+	}
+	for n := 0; n <= maxLen; n++ {
+		if err := solve(n, sha256.Sum256(data[:n])); err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+	}
+	if err := solve(10, sha256.Sum256(data[:11])); err == nil {
+		t.Fatal("hash of another length accepted")
+	}
+	if err := solve(maxLen+1, sha256.Sum256(append(data, 0))); err == nil {
+		t.Fatal("length above the maximum accepted")
+	}
+}
+
+// BenchmarkSha256 compares New and NewLookup on the 512-byte preimage of the accumulator hash.
+func BenchmarkSha256(b *testing.B) {
+	p := preimage(512)
+	for _, c := range []struct {
+		name   string
+		lookup bool
+	}{{"generic", false}, {"lookup", true}} {
+		s := newSetup(b, len(p), c.lookup)
+		w, err := frontend.NewWitness(assignment(p, sha256.Sum256(p)), ecc.BN254.ScalarField())
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportMetric(float64(s.ccs.GetNbConstraints()), "constraints")
+			for i := 0; i < b.N; i++ {
+				if _, err := groth16.Prove(s.ccs, s.pk, w); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSolve measures the witness solving of New on the 512-byte preimage of the
+// accumulator hash, with the sums of roundHint and with t1 and t2 reduced first.
+func BenchmarkSolve(b *testing.B) {
+	p := preimage(512)
+	w, err := frontend.NewWitness(assignment(p, sha256.Sum256(p)), ecc.BN254.ScalarField())
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, reduce := range []bool{false, true} {
+		name := "roundHint"
+		if reduce {
+			name = "reduced"
+		}
+		b.Run(name, func(b *testing.B) {
+			ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &sha256Circuit{Preimage: make([]frontend.Variable, len(p)), reduced: reduce})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := ccs.IsSolved(w); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(ccs.GetNbConstraints()), "constraints")
+		})
+	}
+}
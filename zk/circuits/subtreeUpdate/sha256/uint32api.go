@@ -143,6 +143,19 @@ func (w *uint32api) add(i1, i2 xuint32, in ...xuint32) xuint32 {
 	return res
 }
 
+// fromSum returns the low 32 bits of the sum s of words, the bits of s being given by a hint:
+// they are constrained as by add.
+func (w *uint32api) fromSum(s frontend.Variable, b []frontend.Variable) xuint32 {
+	for i := range b {
+		w.api.AssertIsBoolean(b[i])
+	}
+	w.api.AssertIsEqual(bits.FromBinary(w.api, b, bits.WithUnconstrainedInputs()), s)
+	var res xuint32
+	copy(res[:], b)
+
+	return res
+}
+
 func (w *uint32api) assertEq(a, b xuint32) {
 	for i := range a {
 		w.api.AssertIsEqual(a[i], b[i])
@@ -0,0 +1,78 @@
+package sha256
+
+import (
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/bits"
+)
+
+// SumVariable returns the 32 bytes of the sha256 of the first length bytes of data, length
+// being a variable of the circuit constrained to be at most len(data). The message is padded
+// in the circuit for every length, and the state after the last block of its padding
+// selected. The bytes of data from length on are not constrained. Like Digest.Sum, it
+// returns the error met while hashing.
+func SumVariable(api frontend.API, data []frontend.Variable, length frontend.Variable) ([]frontend.Variable, error) {
+	maxLen := len(data)
+	nbBlocks := (maxLen+8)/chunk + 1
+
+	// isLength[i] is 1 if length is i, and last[b] if b is the last block of the padding
+	isLength := make([]frontend.Variable, maxLen+1)
+	last := make([]frontend.Variable, nbBlocks)
+	for i := range last {
+		last[i] = 0
+	}
+	for i := range isLength {
+		isLength[i] = api.IsZero(api.Sub(length, i))
+		b := (i + 8) / chunk
+		last[b] = api.Add(last[b], isLength[i])
+	}
+	api.AssertIsEqual(sum(api, isLength), 1)
+
+	// the 8 bytes of the length in bits, least significant first
+	nbBits := 1
+	for maxLen>>nbBits > 0 {
+		nbBits++
+	}
+	lengthBits := append([]frontend.Variable{0, 0, 0}, bits.ToBinary(api, length, bits.WithNbDigits(nbBits))...)
+	var lengthBytes [8]frontend.Variable
+	for i := range lengthBytes {
+		lengthBytes[i] = 0
+		for j := 0; j < 8 && 8*i+j < len(lengthBits); j++ {
+			lengthBytes[i] = api.Add(lengthBytes[i], api.Mul(lengthBits[8*i+j], 1<<j))
+		}
+	}
+
+	padded := make([]frontend.Variable, nbBlocks*chunk)
+	before := frontend.Variable(1)
+	for i := range padded {
+		padded[i] = 0
+		if i <= maxLen {
+			// 0x80 right after the message
+			before = api.Sub(before, isLength[i])
+			padded[i] = api.Mul(isLength[i], 0x80)
+		}
+		if i < maxLen {
+			padded[i] = api.Add(padded[i], api.Mul(before, data[i]))
+		}
+		if j := chunk - 1 - i%chunk; j < len(lengthBytes) && 8*j < len(lengthBits) {
+			// the length ending the last block
+			padded[i] = api.Add(padded[i], api.Mul(last[i/chunk], lengthBytes[j]))
+		}
+	}
+
+	d := New(api)
+	res := make([]frontend.Variable, 32)
+	for i := range res {
+		res[i] = 0
+	}
+	for b := range last {
+		d.Write(padded[b*chunk : (b+1)*chunk]...)
+		if d.err != nil {
+			return nil, d.err
+		}
+		state := d.bytes()
+		for i := range res {
+			res[i] = api.Add(res[i], api.Mul(last[b], state[i]))
+		}
+	}
+	return res, nil
+}
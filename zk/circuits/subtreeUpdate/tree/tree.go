@@ -0,0 +1,314 @@
+// Package tree maintains a native copy of the note commitment tree mirrored by
+// libs::offchain_merkle_tree: an append-only Poseidon quadtree filled one batch
+// (subtree) at a time.
+package tree
+
+import (
+	"errors"
+	"fmt"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"math/big"
+	"subtreeUpdate/merkle"
+	"subtreeUpdate/poseidon"
+	"subtreeUpdate/treeutils"
+)
+
+const (
+	// Depth is the number of quadtree levels above the leaves (DEPTH in Move).
+	Depth = 16
+	// Arity is the number of children of every inner node.
+	Arity = 4
+	// BatchSize is the number of leaves inserted by one subtree update (BATCH_SIZE in Move).
+	BatchSize = 16
+	// BatchSubtreeDepth is the depth of the subtree holding one batch (BATCH_SUBTREE_DEPTH in Move).
+	BatchSubtreeDepth = 2
+)
+
+// Params is the shape of the tree of libs::offchain_merkle_tree, merkle.Quad16.
+var Params = merkle.Params{Arity: Arity, Depth: Depth, SubtreeDepth: BatchSubtreeDepth}
+
+var (
+	ErrTreeFull       = errors.New("tree: capacity exceeded")
+	ErrBatchSize      = errors.New("tree: batch must contain exactly one subtree of leaves")
+	ErrBatchAligned   = errors.New("tree: batch must start at a subtree boundary")
+	ErrNodeOutOfTree  = errors.New("tree: node index out of range")
+	ErrLeafNotInField = errors.New("tree: leaf is not a canonical BN254 scalar")
+	ErrParams         = errors.New("tree: unsupported tree parameters")
+)
+
+// emptyRoots returns the roots of the empty subtrees of heights 0 to depth.
+func emptyRoots(arity, depth int) []fr.Element {
+	z := make([]fr.Element, depth+1)
+	children := make([]fr.Element, arity)
+	for i := 1; i <= depth; i++ {
+		for j := range children {
+			children[j] = z[i-1]
+		}
+		z[i] = hashNode(children...)
+	}
+	return z
+}
+
+// zeros are the empty roots of the quadtrees, the deepest having 2^64 leaves.
+var zeros = emptyRoots(Arity, 64/2)
+
+func hashNode(children ...fr.Element) fr.Element {
+	return poseidon.PoseidonExNative(children, fr.Element{}, 1)[0]
+}
+
+// EmptyRoot returns the root of an empty quadtree of the given height, at most 32;
+// EmptyRoot(Depth) is EMPTY_TREE_ROOT.
+func EmptyRoot(height int) *big.Int {
+	return zeros[height].BigInt(new(big.Int))
+}
+
+// Tree is an append-only tree, a quadtree unless built by NewWithParams. The zero value is
+// not usable, call New or NewWithParams.
+type Tree struct {
+	params merkle.Params
+	// nodes[l] holds the non-empty prefix of level l, level 0 being the leaves.
+	nodes [][]fr.Element
+	// zeros[l] is the root of an empty subtree of height l.
+	zeros []fr.Element
+}
+
+// New returns an empty tree of shape Params.
+func New() *Tree {
+	t, err := NewWithParams(Params)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// NewWithParams returns an empty tree of any shape accepted by merkle.Params.Validate, up to
+// 2^64 leaves, filled by subtrees of p.SubtreeDepth levels.
+func NewWithParams(p merkle.Params) (*Tree, error) {
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrParams, err)
+	}
+	t := &Tree{params: p, nodes: make([][]fr.Element, p.Depth+1)}
+	if p.Arity == Arity {
+		t.zeros = zeros[:p.Depth+1]
+	} else {
+		t.zeros = emptyRoots(p.Arity, p.Depth)
+	}
+	t.nodes[p.Depth] = []fr.Element{t.zeros[p.Depth]}
+	return t, nil
+}
+
+// Params returns the shape of the tree.
+func (t *Tree) Params() merkle.Params {
+	return t.params
+}
+
+// BatchSize returns the number of leaves of a batch, the size of a subtree.
+func (t *Tree) BatchSize() int {
+	return t.params.SubtreeSize()
+}
+
+// Clone returns a deep copy of the tree.
+func (t *Tree) Clone() *Tree {
+	c := &Tree{params: t.params, nodes: make([][]fr.Element, len(t.nodes)), zeros: t.zeros}
+	for i := range t.nodes {
+		c.nodes[i] = append([]fr.Element(nil), t.nodes[i]...)
+	}
+	return c
+}
+
+// Count returns the number of leaves inserted so far.
+func (t *Tree) Count() uint64 {
+	return uint64(len(t.nodes[0]))
+}
+
+func (t *Tree) Root() *big.Int {
+	return t.nodes[t.params.Depth][0].BigInt(new(big.Int))
+}
+
+// Node returns the node at the given level (0 for leaves) and index.
+func (t *Tree) Node(level int, index uint64) (*big.Int, error) {
+	if level < 0 || level > t.params.Depth || index>>t.levelBits(level) != 0 {
+		return nil, ErrNodeOutOfTree
+	}
+	n := t.node(level, index)
+	return n.BigInt(new(big.Int)), nil
+}
+
+func (t *Tree) node(level int, index uint64) fr.Element {
+	if index < uint64(len(t.nodes[level])) {
+		return t.nodes[level][index]
+	}
+	return t.zeros[level]
+}
+
+// levelBits is the number of bits of the index of a node at the given level.
+func (t *Tree) levelBits(level int) uint {
+	return uint(t.params.IndexBits() * (t.params.Depth - level))
+}
+
+var noteLeafMask = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), treeutils.FieldElemLoBits), big.NewInt(1))
+
+// NoteLeaf returns the leaf of a note commitment, the 32-byte sha256 hash of the note
+// stored by insert_note: its low limb of u256_to_field_elem_limbs, the low
+// treeutils.FieldElemLoBits bits read big-endian. The top 3 bits are dropped so that every
+// leaf is below r.
+func NoteLeaf(h [32]byte) *big.Int {
+	l := new(big.Int).SetBytes(h[:])
+	return l.And(l, noteLeafMask)
+}
+
+// Insert appends leaves to the tree. Leaves must be in [0, r), they are rejected rather
+// than reduced.
+func (t *Tree) Insert(leaves ...*big.Int) error {
+	if len(leaves) == 0 {
+		return nil
+	}
+	// the index of the last leaf, so that a tree of 2^64 leaves does not overflow
+	last := uint64(1)<<t.levelBits(0) - 1
+	if t.Count() > last || uint64(len(leaves))-1 > last-t.Count() {
+		return ErrTreeFull
+	}
+	for _, l := range leaves {
+		if l.Sign() < 0 || l.Cmp(ecc.BN254.ScalarField()) >= 0 {
+			return ErrLeafNotInField
+		}
+	}
+	start := t.Count()
+	for _, l := range leaves {
+		var e fr.Element
+		e.SetBigInt(l)
+		t.nodes[0] = append(t.nodes[0], e)
+	}
+	end := t.Count()
+	arity := uint64(t.params.Arity)
+	children := make([]fr.Element, arity)
+	for level := 1; level <= t.params.Depth; level++ {
+		start, end = start/arity, (end-1)/arity+1
+		for i := start; i < end; i++ {
+			for c := range children {
+				children[c] = t.node(level-1, i*arity+uint64(c))
+			}
+			n := hashNode(children...)
+			if i < uint64(len(t.nodes[level])) {
+				t.nodes[level][i] = n
+			} else {
+				t.nodes[level] = append(t.nodes[level], n)
+			}
+		}
+	}
+	return nil
+}
+
+// InsertBatch appends one full batch, filling the next subtree.
+func (t *Tree) InsertBatch(batch []*big.Int) error {
+	if len(batch) != t.BatchSize() {
+		return ErrBatchSize
+	}
+	if t.Count()%uint64(t.BatchSize()) != 0 {
+		return ErrBatchAligned
+	}
+	return t.Insert(batch...)
+}
+
+// Proof is the authentication path of the node at Height and Index up to the root.
+// Siblings[i] lists, in order, the Arity-1 other children of the level-i ancestor's parent.
+type Proof struct {
+	Root     *big.Int
+	Node     *big.Int
+	Height   int
+	Index    uint64
+	Arity    int
+	Siblings [][]*big.Int
+}
+
+// Prove returns the proof of the node at the given height (0 for leaves) and index.
+func (t *Tree) Prove(height int, index uint64) (*Proof, error) {
+	node, err := t.Node(height, index)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proof{
+		Root:   t.Root(),
+		Node:   node,
+		Height: height,
+		Index:  index,
+		Arity:  t.params.Arity,
+	}
+	arity := uint64(t.params.Arity)
+	for level := height; level < t.params.Depth; level++ {
+		var s []*big.Int
+		digit := index % arity
+		for c := uint64(0); c < arity; c++ {
+			if c != digit {
+				n := t.node(level, index-digit+c)
+				s = append(s, n.BigInt(new(big.Int)))
+			}
+		}
+		p.Siblings = append(p.Siblings, s)
+		index /= arity
+	}
+	return p, nil
+}
+
+// ProveLeaf returns the proof of the leaf at index.
+func (t *Tree) ProveLeaf(index uint64) (*Proof, error) {
+	return t.Prove(0, index)
+}
+
+// ProveSubtree returns the proof of the subtree holding batch subtreeIndex, i.e. the
+// leaves [subtreeIndex*t.BatchSize(), (subtreeIndex+1)*t.BatchSize()).
+func (t *Tree) ProveSubtree(subtreeIndex uint64) (*Proof, error) {
+	return t.Prove(t.params.SubtreeDepth, subtreeIndex)
+}
+
+// PathIndices returns, for every level, the position (0 to Arity-1) of the path among its
+// siblings.
+func (p *Proof) PathIndices() []int {
+	res := make([]int, len(p.Siblings))
+	index := p.Index
+	for i := range res {
+		res[i] = int(index % uint64(p.Arity))
+		index /= uint64(p.Arity)
+	}
+	return res
+}
+
+// Verify recomputes the root from Node and Siblings.
+func (p *Proof) Verify() bool {
+	var current fr.Element
+	current.SetBigInt(p.Node)
+	children := make([]fr.Element, p.Arity)
+	for i, digit := range p.PathIndices() {
+		j := 0
+		for c := range children {
+			if c == digit {
+				children[c] = current
+				continue
+			}
+			children[c].SetBigInt(p.Siblings[i][j])
+			j++
+		}
+		current = hashNode(children...)
+	}
+	var root fr.Element
+	root.SetBigInt(p.Root)
+	return current.Equal(&root)
+}
+
+// MerkleProof returns the proof as a merkle.MerkleProof assignment.
+func (p *Proof) MerkleProof() merkle.MerkleProof {
+	params := merkle.Params{Arity: p.Arity}
+	res := params.NewMerkleProof(len(p.Siblings))
+	res.RootHash = p.Root
+	res.Leaf = p.Node
+	for i, digit := range p.PathIndices() {
+		for j := range res.PathIndices[i] {
+			res.PathIndices[i][j] = digit >> (len(res.PathIndices[i]) - 1 - j) & 1
+		}
+		for j := range p.Siblings[i] {
+			res.Siblings[i][j] = p.Siblings[i][j]
+		}
+	}
+	return res
+}
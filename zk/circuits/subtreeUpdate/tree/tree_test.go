@@ -0,0 +1,323 @@
+package tree
+
+import (
+	"errors"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+	"math/big"
+	"subtreeUpdate/merkle"
+	"subtreeUpdate/poseidon"
+	"subtreeUpdate/treeutils"
+	"testing"
+)
+
+// toBigInts reduces the values modulo r, as the hand-written assignments of main.go did.
+func toBigInts(t *testing.T, in []string) []*big.Int {
+	res := make([]*big.Int, len(in))
+	for i, s := range in {
+		b, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			t.Fatalf("invalid integer %q", s)
+		}
+		res[i] = b.Mod(b, ecc.BN254.ScalarField())
+	}
+	return res
+}
+
+// the two batches used by the hand-written assignments in main.go
+var firstBatch = []string{
+	"7559412695850999704437639814226631134667359700514660715427262528648684612384",
+	"66128905217727820142075711671179697108908215459957692935244063164243782161424",
+	"51015742989614192140374653588448216776344032110315281841496138794886522140476",
+	"35122383026158949466484037373710698093278849499198161694631609784776227649041",
+	"26172153391189409300153675552195806917108259526780793769448239894068277983117",
+	"26319945699020872042776764262800211039811709625022690029869433243912894238514",
+	"85459787920741173308529179304076764583420917452546478748737995277072485407899",
+	"37824474589860659728395896987471423117349358731852046326799624553171445743149",
+	"61194402916979300094031158454825880129228850504669718400883285170758259346137",
+	"24246882173524206786121934947990875280633571158623508995012743986254503068477",
+	"93199772650225608593183888507906173669398210074847791089995208298919581733292",
+	"33909163889869673678628757617712328003205266681277740626071514924998877887543",
+	"57565043458143669655443451912855736172750697736668570413272031819358780748047",
+	"37243082771427767710089206757934373481061954243301630231967571484585860082658",
+	"16563559798946351326855328924389131968545894673507955726309781333266729822892",
+	"48994785319657803905781873709543292037955196759232529867686143523322370022071",
+}
+
+var secondBatch = []string{
+	"8156319925050744557782245694037100563564059020340687679749164066021286143836",
+	"95909223809388993694993492400467043881733915630342324449340732043292438402430",
+	"57414147262588752917658270334485249249923597373198409036320819119810373085930",
+	"113670449272529920882410221941222150904120358535747236216730630644724274198177",
+	"57398552932645399891307139678071663482334851558675487776494006109344731328249",
+	"103143881793116431352669765361473968236332048388061021423670947940287385762294",
+	"81612725631244049093044665038120176880687858193615788788599062677724186199322",
+	"24221479052158155394601047524206106254731735391378752996520107136176279845840",
+	"49556574245612851804963807434730031772247272089317479498002818782916042154755",
+	"56426423107537836944547904423637136789783854836853497598720872599439703238350",
+	"20422616371558051321328641762545775674999844341281317444259962231474038621913",
+	"70721761249807583889417427160262976417209826966551495121360338241351139517252",
+	"67154409164492473022545374284896465659184269211491885695865972146826122887517",
+	"14641621711817804362131083482888050433544024750770175084260876296122465116391",
+	"44449413951567356658450979332805732743618650826831158077837287657342508935054",
+	"49888894850490203642386712655024128420501187089752845687448626231471203382973",
+}
+
+func TestEmptyRoot(t *testing.T) {
+	tr := New()
+	want := "9533201250583817767896570092866591469094150406835227552485691564931228351592"
+	if got := tr.Root().String(); got != want {
+		t.Fatalf("empty root: got %s, want %s", got, want)
+	}
+	if got := EmptyRoot(BatchSubtreeDepth).String(); got != "13867732332339151465497925642082178974038372652152621168903203076445231043372" {
+		t.Fatalf("empty subtree root: got %s", got)
+	}
+}
+
+func TestInsertBatchMatchesMainVectors(t *testing.T) {
+	tr := New()
+	if err := tr.InsertBatch(toBigInts(t, firstBatch)); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.Root().String(); got != "14751455653696551972598626902324480412263087291693189381022091614544374105930" {
+		t.Fatalf("root after first batch: got %s", got)
+	}
+	if err := tr.InsertBatch(toBigInts(t, secondBatch)); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.Root().String(); got != "20124835335623687480810663312320583159298054440202737370297960754448161307188" {
+		t.Fatalf("root after second batch: got %s", got)
+	}
+
+	p, err := tr.ProveSubtree(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Node.String() != "10311198283923373016267585188573545952089761138791529851438417096351462635353" {
+		t.Fatalf("subtree root: got %s", p.Node)
+	}
+	if p.Siblings[0][0].String() != "19308544306448469788048112111319950102602262858931723855938361542409857985469" {
+		t.Fatalf("first sibling: got %s", p.Siblings[0][0])
+	}
+	if !p.Verify() {
+		t.Fatal("subtree proof does not verify")
+	}
+}
+
+func TestInsertBatchErrors(t *testing.T) {
+	tr := New()
+	if err := tr.InsertBatch(toBigInts(t, firstBatch[:3])); err != ErrBatchSize {
+		t.Fatalf("got %v, want %v", err, ErrBatchSize)
+	}
+	if err := tr.Insert(big.NewInt(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.InsertBatch(toBigInts(t, firstBatch)); err != ErrBatchAligned {
+		t.Fatalf("got %v, want %v", err, ErrBatchAligned)
+	}
+}
+
+func TestNoteLeaf(t *testing.T) {
+	var h [32]byte
+	for i := range h {
+		h[i] = 0xff
+	}
+	want := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), treeutils.FieldElemLoBits), big.NewInt(1))
+	if got := NoteLeaf(h); got.Cmp(want) != 0 {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	h[0] = 0x1f
+	if got := NoteLeaf(h); got.Cmp(want) != 0 {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	h = [32]byte{0: 0xe0, 31: 0x2a}
+	if got := NoteLeaf(h); got.Cmp(big.NewInt(0x2a)) != 0 {
+		t.Fatalf("got %s, want 42", got)
+	}
+}
+
+func TestInsertRejectsLeavesOutsideField(t *testing.T) {
+	tr := New()
+	r := ecc.BN254.ScalarField()
+	for _, l := range []*big.Int{r, new(big.Int).Add(r, big.NewInt(1)), big.NewInt(-1)} {
+		if err := tr.Insert(big.NewInt(1), l); err != ErrLeafNotInField {
+			t.Fatalf("%s: got %v, want %v", l, err, ErrLeafNotInField)
+		}
+	}
+	if tr.Count() != 0 {
+		t.Fatal("rejected batch was partially inserted")
+	}
+	if err := tr.Insert(new(big.Int).Sub(r, big.NewInt(1))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalInsertMatchesBatch(t *testing.T) {
+	a, b := New(), New()
+	leaves := toBigInts(t, append(append([]string{}, firstBatch...), secondBatch...))
+	for _, l := range leaves {
+		if err := a.Insert(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Insert(leaves...); err != nil {
+		t.Fatal(err)
+	}
+	if a.Root().Cmp(b.Root()) != 0 {
+		t.Fatalf("roots differ: %s != %s", a.Root(), b.Root())
+	}
+	for i := range leaves {
+		p, err := a.ProveLeaf(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !p.Verify() {
+			t.Fatalf("leaf %d proof does not verify", i)
+		}
+	}
+}
+
+type verifyProofCircuit struct {
+	Proof merkle.MerkleProof
+}
+
+func (c *verifyProofCircuit) Define(api frontend.API) error {
+	c.Proof.VerifyProof(api, poseidon.NewPoseidonHash(api))
+	return nil
+}
+
+func TestSubtreeProofSatisfiesGadget(t *testing.T) {
+	tr := New()
+	for i := 0; i < 6; i++ {
+		batch := make([]*big.Int, BatchSize)
+		for j := range batch {
+			batch[j] = big.NewInt(int64(i*BatchSize + j + 1))
+		}
+		if err := tr.InsertBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// every position among siblings, on the first and the second level
+	for _, idx := range []uint64{0, 1, 2, 3, 4, 5} {
+		p, err := tr.ProveSubtree(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		circuit := &verifyProofCircuit{Proof: Params.NewSubtreeProof()}
+		if err := test.IsSolved(circuit, &verifyProofCircuit{Proof: p.MerkleProof()}, ecc.BN254.ScalarField()); err != nil {
+			t.Fatalf("subtree %d: %v", idx, err)
+		}
+	}
+
+	// leaf proofs have Depth levels
+	for _, idx := range []uint64{0, 5, 17, 95} {
+		p, err := tr.ProveLeaf(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		circuit := &verifyProofCircuit{Proof: Params.NewMerkleProof(Depth)}
+		if err := test.IsSolved(circuit, &verifyProofCircuit{Proof: p.MerkleProof()}, ecc.BN254.ScalarField()); err != nil {
+			t.Fatalf("leaf %d: %v", idx, err)
+		}
+	}
+}
+
+func TestNewWithParams(t *testing.T) {
+	p := merkle.Params{Arity: Arity, Depth: 3, SubtreeDepth: 1}
+	tr, err := NewWithParams(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Root().Cmp(EmptyRoot(3)) != 0 || tr.BatchSize() != 4 {
+		t.Fatalf("empty tree of depth 3 has root %s, batches of %d", tr.Root(), tr.BatchSize())
+	}
+	for i := 0; i < 16; i++ {
+		batch := []*big.Int{big.NewInt(int64(4 * i)), big.NewInt(1), big.NewInt(2), big.NewInt(3)}
+		if err := tr.InsertBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		p, err := tr.ProveSubtree(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Siblings) != 2 || !p.Verify() {
+			t.Fatalf("subtree %d: proof of %d levels does not verify", i, len(p.Siblings))
+		}
+	}
+	if err := tr.InsertBatch(make([]*big.Int, 4)); err != ErrTreeFull {
+		t.Fatalf("got %v, want %v", err, ErrTreeFull)
+	}
+	if err := New().InsertBatch(make([]*big.Int, 4)); err != ErrBatchSize {
+		t.Fatalf("got %v, want %v", err, ErrBatchSize)
+	}
+
+	for _, p := range []merkle.Params{
+		{Arity: 3, Depth: 16, SubtreeDepth: 1},
+		{Arity: Arity, Depth: 33, SubtreeDepth: 2},
+		{Arity: Arity, Depth: 1, SubtreeDepth: 2},
+	} {
+		if _, err := NewWithParams(p); !errors.Is(err, ErrParams) {
+			t.Errorf("%+v: got %v, want %v", p, err, ErrParams)
+		}
+	}
+}
+
+func TestOtherShapes(t *testing.T) {
+	for _, p := range []merkle.Params{
+		{Arity: 2, Depth: 5, SubtreeDepth: 2},
+		{Arity: 8, Depth: 3, SubtreeDepth: 1},
+	} {
+		tr, err := NewWithParams(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			batch := make([]*big.Int, tr.BatchSize())
+			for j := range batch {
+				batch[j] = big.NewInt(int64(i*len(batch) + j + 1))
+			}
+			if err := tr.InsertBatch(batch); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, idx := range []uint64{0, 2, 9, 20} {
+			pr, err := tr.ProveLeaf(idx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			circuit := &verifyProofCircuit{Proof: p.NewMerkleProof(p.Depth)}
+			if err := test.IsSolved(circuit, &verifyProofCircuit{Proof: pr.MerkleProof()}, ecc.BN254.ScalarField()); err != nil {
+				t.Fatalf("%+v, leaf %d: %v", p, idx, err)
+			}
+		}
+	}
+
+	// the largest trees have 2^64 leaves
+	for _, p := range []merkle.Params{
+		{Arity: 2, Depth: 64, SubtreeDepth: 4},
+		{Arity: Arity, Depth: 32, SubtreeDepth: 2},
+	} {
+		tr, err := NewWithParams(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch := make([]*big.Int, tr.BatchSize())
+		for j := range batch {
+			batch[j] = big.NewInt(int64(j))
+		}
+		if err := tr.InsertBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tr.Node(0, ^uint64(0)); err != nil {
+			t.Fatalf("%+v: %v", p, err)
+		}
+		pr, err := tr.ProveSubtree(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pr.Verify() {
+			t.Fatalf("%+v: proof does not verify", p)
+		}
+	}
+}
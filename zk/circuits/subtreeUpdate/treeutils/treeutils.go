@@ -0,0 +1,94 @@
+// Package treeutils mirrors libs::tree_utils, which encodes the public inputs of a subtree
+// update on-chain.
+package treeutils
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"subtreeUpdate/merkle"
+)
+
+// FieldElemLoBits is the size of the low limb of u256_to_field_elem_limbs.
+const FieldElemLoBits = 253
+
+var (
+	ErrSubtreeIdx      = errors.New("treeutils: subtree index is not a multiple of the batch size")
+	ErrPathOutOfTree   = errors.New("treeutils: subtree index out of the tree")
+	ErrInvalidParams   = errors.New("treeutils: invalid tree parameters")
+	ErrEncodingTooLong = errors.New("treeutils: encoded path and hash is negative or longer than 256 bits")
+)
+
+// Params are the constants of tree_utils.
+type Params struct {
+	// Depth is DEPTH, the number of quadtree levels above the leaves.
+	Depth int
+	// BatchSize is BATCH_SIZE, the number of leaves of a subtree.
+	BatchSize uint64
+	// BatchSubtreeDepth is BATCH_SUBTREE_DEPTH, the depth of a subtree.
+	BatchSubtreeDepth int
+}
+
+// Default are the parameters of the deployed tree, merkle.Quad16.
+var Default = Params{Depth: merkle.Quad16.Depth, BatchSize: uint64(merkle.Quad16.SubtreeSize()), BatchSubtreeDepth: merkle.Quad16.SubtreeDepth}
+
+func (p Params) validate() error {
+	if p.BatchSubtreeDepth < 0 || p.Depth < p.BatchSubtreeDepth || 2*p.Depth > 64 || p.BatchSize == 0 {
+		return fmt.Errorf("%w: %+v", ErrInvalidParams, p)
+	}
+	return nil
+}
+
+// PathBits is the size of the subtree path in an encoded path and hash, two bits per level
+// above the subtree.
+func (p Params) PathBits() int {
+	return 2 * (p.Depth - p.BatchSubtreeDepth)
+}
+
+// EncodePathAndHash mirrors encode_path_and_hash: subtreeIdx, the index of the first leaf of
+// the subtree, shifted to the index of the subtree in the low PathBits bits, followed by
+// accumulatorHashHi. Unlike Move, it also rejects subtrees out of the tree, whose path would
+// overflow into the hash.
+func (p Params) EncodePathAndHash(subtreeIdx uint64, accumulatorHashHi *big.Int) (*big.Int, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if subtreeIdx%p.BatchSize != 0 {
+		return nil, ErrSubtreeIdx
+	}
+	path := subtreeIdx >> (2 * p.BatchSubtreeDepth)
+	if path>>p.PathBits() != 0 {
+		return nil, ErrPathOutOfTree
+	}
+	res := new(big.Int).Lsh(accumulatorHashHi, uint(p.PathBits()))
+	return res.Or(res, new(big.Int).SetUint64(path)), nil
+}
+
+// DecodePathAndHash inverts EncodePathAndHash, as the circuit does with the bits of
+// encodedPathAndHash.
+func (p Params) DecodePathAndHash(encoded *big.Int) (subtreeIdx uint64, accumulatorHashHi *big.Int, err error) {
+	if err := p.validate(); err != nil {
+		return 0, nil, err
+	}
+	if encoded.Sign() < 0 || encoded.BitLen() > 256 {
+		return 0, nil, ErrEncodingTooLong
+	}
+	pathBits := uint(p.PathBits())
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), pathBits), big.NewInt(1))
+	path := new(big.Int).And(encoded, mask).Uint64()
+	return path << (2 * p.BatchSubtreeDepth), new(big.Int).Rsh(encoded, pathBits), nil
+}
+
+// SplitU256ToLimbs mirrors split_u256_to_limbs: the bits of n above lowerBits, and the
+// lowerBits low ones.
+func SplitU256ToLimbs(n *big.Int, lowerBits uint) (hi, lo *big.Int) {
+	hi = new(big.Int).Rsh(n, lowerBits)
+	lo = new(big.Int).Sub(n, new(big.Int).Lsh(hi, lowerBits))
+	return hi, lo
+}
+
+// U256ToFieldElemLimbs mirrors u256_to_field_elem_limbs, which splits a sha256 hash in a
+// 3-bit high limb and a 253-bit low limb, both below the BN254 scalar field.
+func U256ToFieldElemLimbs(n *big.Int) (hi, lo *big.Int) {
+	return SplitU256ToLimbs(n, FieldElemLoBits)
+}
@@ -0,0 +1,114 @@
+package treeutils
+
+import (
+	"math/big"
+	"testing"
+)
+
+func bigInt(t *testing.T, s string) *big.Int {
+	b, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		t.Fatalf("invalid integer %q", s)
+	}
+	return b
+}
+
+// the accumulator hash of create_encoded_note(1, 1, i, 1) for i in 0..16, asserted in
+// test_update_tree_and_verify_proof
+const moveAccumulatorHash = "35391017205645498333932229288428234435008798448262589111347252333824232751540"
+
+// TestMoveVectors checks the values asserted by the tests of tree_utils_test.move.
+func TestMoveVectors(t *testing.T) {
+	for _, c := range []struct {
+		subtreeIdx uint64
+		hi         int64
+		want       string
+	}{
+		{0, 0, "0"},
+		{16, 7, "1879048193"},
+		{4096, 5, "1342177536"},
+		{1<<32 - 16, 7, "2147483647"},
+	} {
+		got, err := Default.EncodePathAndHash(c.subtreeIdx, big.NewInt(c.hi))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != c.want {
+			t.Errorf("encode_path_and_hash(%d, %d) = %s, want %s", c.subtreeIdx, c.hi, got, c.want)
+		}
+	}
+
+	for _, c := range []struct{ n, hi, lo string }{
+		{moveAccumulatorHash, "2", "6442994896316449478039483036256257471691302281852448101482856331845950341556"},
+		{"115792089237316195423570985008687907853269984665640564039457584007913129639935", "7", "14474011154664524427946373126085988481658748083205070504932198000989141204991"},
+		{"0", "0", "0"},
+	} {
+		hi, lo := U256ToFieldElemLimbs(bigInt(t, c.n))
+		if hi.String() != c.hi || lo.String() != c.lo {
+			t.Errorf("u256_to_field_elem_limbs(%s) = (%s, %s), want (%s, %s)", c.n, hi, lo, c.hi, c.lo)
+		}
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	for _, p := range []Params{
+		Default,
+		{Depth: 4, BatchSize: 4, BatchSubtreeDepth: 1},
+		{Depth: 20, BatchSize: 64, BatchSubtreeDepth: 3},
+	} {
+		nbSubtrees := uint64(1) << p.PathBits()
+		hi, _ := U256ToFieldElemLimbs(bigInt(t, moveAccumulatorHash))
+		for _, path := range []uint64{0, 1, 2, nbSubtrees / 3, nbSubtrees - 1} {
+			subtreeIdx := path << (2 * p.BatchSubtreeDepth)
+			encoded, err := p.EncodePathAndHash(subtreeIdx, hi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if encoded.BitLen() > p.PathBits()+3 {
+				t.Fatalf("%+v: encoding of %d has %d bits", p, subtreeIdx, encoded.BitLen())
+			}
+			gotIdx, gotHi, err := p.DecodePathAndHash(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotIdx != subtreeIdx || gotHi.Cmp(hi) != 0 {
+				t.Fatalf("%+v: decoded (%d, %s), want (%d, %s)", p, gotIdx, gotHi, subtreeIdx, hi)
+			}
+		}
+
+		if _, err := p.EncodePathAndHash(p.BatchSize/2, hi); err != ErrSubtreeIdx {
+			t.Fatalf("%+v: got %v, want %v", p, err, ErrSubtreeIdx)
+		}
+		if _, err := p.EncodePathAndHash(nbSubtrees<<(2*p.BatchSubtreeDepth), hi); err != ErrPathOutOfTree {
+			t.Fatalf("%+v: got %v, want %v", p, err, ErrPathOutOfTree)
+		}
+	}
+}
+
+func TestSplitU256ToLimbs(t *testing.T) {
+	n := bigInt(t, moveAccumulatorHash)
+	for _, lowerBits := range []uint{0, 1, 128, 253, 256} {
+		hi, lo := SplitU256ToLimbs(n, lowerBits)
+		if lo.BitLen() > int(lowerBits) {
+			t.Fatalf("%d: low limb has %d bits", lowerBits, lo.BitLen())
+		}
+		if got := new(big.Int).Add(new(big.Int).Lsh(hi, lowerBits), lo); got.Cmp(n) != 0 {
+			t.Fatalf("%d: limbs recombine to %s", lowerBits, got)
+		}
+	}
+}
+
+func TestInvalidParams(t *testing.T) {
+	for _, p := range []Params{
+		{},
+		{Depth: 1, BatchSize: 16, BatchSubtreeDepth: 2},
+		{Depth: 33, BatchSize: 16, BatchSubtreeDepth: 2},
+	} {
+		if _, err := p.EncodePathAndHash(0, big.NewInt(0)); err == nil {
+			t.Errorf("%+v: no error", p)
+		}
+		if _, _, err := p.DecodePathAndHash(big.NewInt(0)); err == nil {
+			t.Errorf("%+v: no error", p)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"github.com/consensys/gnark/backend/groth16"
+	"subtreeUpdate/circuit"
+	"subtreeUpdate/export"
+	"subtreeUpdate/prover"
+	"subtreeUpdate/tree"
+)
+
+// Groth16Prover is the MultiProver of the groth16 keys of the SubtreeUpdateCircuit of
+// Config. Its proofs are encoded for sui::groth16::proof_points_from_bytes.
+type Groth16Prover struct {
+	Config circuit.Config
+	Keys   *prover.Keys
+	// Multi are keys of the MultiSubtreeUpdateCircuit of Config, one per number of batches.
+	Multi []*prover.Keys
+}
+
+func (p *Groth16Prover) Prove(ctx context.Context, t *tree.Tree, b *Batch) ([]byte, error) {
+	proof, err := p.prove(ctx, t, b)
+	if err != nil {
+		return nil, err
+	}
+	return export.SuiProof(proof)
+}
+
+// prove builds the witness of b and proves it. A batch whose accumulator hash is not the
+// one of its leaves is refused, since the contract would reject its proof.
+func (p *Groth16Prover) prove(ctx context.Context, t *tree.Tree, b *Batch) (groth16.Proof, error) {
+	w, err := p.Config.BuildWitness(t, b.Leaves)
+	if err != nil {
+		return nil, err
+	}
+	if w.AccumulatorHash.Cmp(b.AccumulatorHash) != 0 {
+		return nil, fmt.Errorf("updater: batch %d has accumulator hash %s, its leaves %s", b.Index, b.AccumulatorHash, w.AccumulatorHash)
+	}
+	// groth16.Prove cannot be interrupted, at least do not start it
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return groth16.Prove(p.Keys.CCS, p.Keys.PK, w.Full)
+}
+
+func (p *Groth16Prover) NbBatches() []int {
+	var res []int
+	for _, k := range p.Multi {
+		res = append(res, k.NbBatches)
+	}
+	return res
+}
+
+func (p *Groth16Prover) ProveBatches(ctx context.Context, t *tree.Tree, batches []*Batch) ([]byte, error) {
+	proof, err := p.proveBatches(ctx, t, batches)
+	if err != nil {
+		return nil, err
+	}
+	return export.SuiProof(proof)
+}
+
+func (p *Groth16Prover) proveBatches(ctx context.Context, t *tree.Tree, batches []*Batch) (groth16.Proof, error) {
+	var keys *prover.Keys
+	for _, k := range p.Multi {
+		if k.NbBatches == len(batches) {
+			keys = k
+		}
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("updater: no keys for %d batches", len(batches))
+	}
+	leaves := make([][][32]byte, len(batches))
+	for i, b := range batches {
+		leaves[i] = b.Leaves
+	}
+	w, err := p.Config.BuildMultiWitness(t, leaves)
+	if err != nil {
+		return nil, err
+	}
+	for i, b := range batches {
+		if w.AccumulatorHashes[i].Cmp(b.AccumulatorHash) != 0 {
+			return nil, fmt.Errorf("updater: batch %d has accumulator hash %s, its leaves %s", b.Index, b.AccumulatorHash, w.AccumulatorHashes[i])
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return groth16.Prove(keys.CCS, keys.PK, w.Full)
+}
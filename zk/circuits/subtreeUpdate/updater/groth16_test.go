@@ -0,0 +1,116 @@
+package updater
+
+import (
+	"context"
+	"github.com/consensys/gnark/backend/groth16"
+	"math/big"
+	"path/filepath"
+	"subtreeUpdate/circuit"
+	"subtreeUpdate/prover"
+	"subtreeUpdate/tree"
+	"testing"
+)
+
+// poseidonChain serves the batches of MemChain with Poseidon accumulator hashes, whose
+// circuit is small enough to set up in a test.
+type poseidonChain struct {
+	*MemChain
+}
+
+func (c poseidonChain) Batch(ctx context.Context, index uint64) (*Batch, error) {
+	b, err := c.MemChain.Batch(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+	return &Batch{Index: b.Index, AccumulatorHash: circuit.PoseidonAccumulatorHash(b.Leaves), Leaves: b.Leaves}, nil
+}
+
+func newGroth16Prover(t *testing.T) *Groth16Prover {
+	c := circuit.Config{Depth: tree.Depth, BatchSize: tree.BatchSize, Accumulator: circuit.Poseidon}
+	dir := t.TempDir()
+	single, err := prover.LoadOrSetup(dir, c, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	double, err := prover.LoadOrSetup(dir, c, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Groth16Prover{Config: c, Keys: single, Multi: []*prover.Keys{double}}
+}
+
+func TestGroth16Prover(t *testing.T) {
+	p := newGroth16Prover(t)
+	mem := NewMemChain()
+	insertNotes(mem, 0, 2*tree.BatchSize)
+	chain := poseidonChain{mem}
+	ctx := context.Background()
+
+	// the proof of the first batch verifies against the public inputs of the contract
+	b, err := chain.Batch(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tree.New()
+	proof, err := p.prove(ctx, tr, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := tr.Clone()
+	for _, l := range b.Leaves {
+		if err := next.Insert(tree.NoteLeaf(l)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	public, err := p.Config.PublicWitness(tr.Root(), next.Root(), 0, b.AccumulatorHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := groth16.Verify(proof, p.Keys.VK, public); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Count() != 0 {
+		t.Fatal("proving modified the tree")
+	}
+
+	// a batch of another accumulator is refused
+	sha, err := mem.Batch(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Prove(ctx, tr, sha); err == nil {
+		t.Fatal("batch of another accumulator hash proven")
+	}
+
+	// so does the proof of both batches
+	b1, err := chain.Batch(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range b1.Leaves {
+		if err := next.Insert(tree.NoteLeaf(l)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if proof, err = p.proveBatches(ctx, tr, []*Batch{b, b1}); err != nil {
+		t.Fatal(err)
+	}
+	if public, err = p.Config.MultiPublicWitness(tr.Root(), next.Root(), 0, []*big.Int{b.AccumulatorHash, b1.AccumulatorHash}); err != nil {
+		t.Fatal(err)
+	}
+	if err := groth16.Verify(proof, p.Multi[0].VK, public); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ProveBatches(ctx, tr, []*Batch{b, b1, b1}); err == nil {
+		t.Fatal("3 batches proven without their keys")
+	}
+
+	// the updater proves both batches at once, then the last one
+	insertNotes(mem, 2*tree.BatchSize, tree.BatchSize)
+	u := newUpdater(t, chain, p, filepath.Join(t.TempDir(), "checkpoint.json"))
+	drain(t, u)
+	root, count, _ := chain.State(ctx)
+	if count != 3*tree.BatchSize || u.Root().Cmp(root) != 0 {
+		t.Fatalf("updater at %d/%s, chain at %d/%s", u.Count(), u.Root(), count, root)
+	}
+}
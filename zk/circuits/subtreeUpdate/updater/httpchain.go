@@ -0,0 +1,155 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// HTTPChain is a ChainClient for a relay that reads and calls libs::offchain_merkle_tree on
+// behalf of the updater, since the contract emits no events to follow. The relay serves
+// JSON, with field elements as decimal strings and bytes as 0x-prefixed hexadecimal:
+//
+//	GET  {URL}/state        {"root": "<field>", "count": <leaves>}
+//	GET  {URL}/batches/{i}  {"index": i, "accumulatorHash": "<field>", "leaves": ["<32 bytes>", ...]},
+//	                        404 if the batch has not been accumulated yet
+//	POST {URL}/updates      {"batchIndex": i, "nbBatches": n, "oldRoot": "<field>", "newRoot": "<field>", "proof": "<bytes>"}
+//
+// The relay answers a failed update with an error status and the reason as body.
+type HTTPChain struct {
+	URL    string
+	Client *http.Client
+}
+
+var errNotFound = errors.New("httpchain: not found")
+
+type httpState struct {
+	Root  string `json:"root"`
+	Count uint64 `json:"count"`
+}
+
+type httpBatch struct {
+	Index           uint64   `json:"index"`
+	AccumulatorHash string   `json:"accumulatorHash"`
+	Leaves          []string `json:"leaves"`
+}
+
+type httpUpdate struct {
+	BatchIndex uint64 `json:"batchIndex"`
+	NbBatches  int    `json:"nbBatches"`
+	OldRoot    string `json:"oldRoot"`
+	NewRoot    string `json:"newRoot"`
+	Proof      string `json:"proof"`
+}
+
+func (c *HTTPChain) State(ctx context.Context) (*big.Int, uint64, error) {
+	var s httpState
+	if err := c.do(ctx, http.MethodGet, "/state", nil, &s); err != nil {
+		return nil, 0, err
+	}
+	root, err := parseField(s.Root)
+	if err != nil {
+		return nil, 0, err
+	}
+	return root, s.Count, nil
+}
+
+func (c *HTTPChain) Batch(ctx context.Context, index uint64) (*Batch, error) {
+	var hb httpBatch
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/batches/%d", index), nil, &hb)
+	if errors.Is(err, errNotFound) {
+		return nil, ErrNoBatch
+	}
+	if err != nil {
+		return nil, err
+	}
+	if hb.Index != index {
+		return nil, fmt.Errorf("httpchain: got batch %d, want %d", hb.Index, index)
+	}
+	b := &Batch{Index: hb.Index, Leaves: make([][32]byte, len(hb.Leaves))}
+	if b.AccumulatorHash, err = parseField(hb.AccumulatorHash); err != nil {
+		return nil, err
+	}
+	for i, l := range hb.Leaves {
+		data, err := parseBytes(l)
+		if err != nil || len(data) != len(b.Leaves[i]) {
+			return nil, fmt.Errorf("httpchain: invalid leaf %q of batch %d", l, index)
+		}
+		copy(b.Leaves[i][:], data)
+	}
+	return b, nil
+}
+
+func (c *HTTPChain) ApplySubtreeUpdate(ctx context.Context, u *Update) error {
+	return c.do(ctx, http.MethodPost, "/updates", &httpUpdate{
+		BatchIndex: u.BatchIndex,
+		NbBatches:  u.nbBatches(),
+		OldRoot:    u.OldRoot.String(),
+		NewRoot:    u.NewRoot.String(),
+		Proof:      "0x" + hex.EncodeToString(u.Proof),
+	}, nil)
+}
+
+// do sends in as the JSON body of the request, and decodes the response into out unless it
+// is nil. A 404 fails with errNotFound.
+func (c *HTTPChain) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s %s", errNotFound, method, path)
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("httpchain: %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	case out == nil:
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("httpchain: %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+func parseField(s string) (*big.Int, error) {
+	x, ok := new(big.Int).SetString(s, 10)
+	if !ok || x.Sign() < 0 {
+		return nil, fmt.Errorf("httpchain: invalid field element %q", s)
+	}
+	return x, nil
+}
+
+func parseBytes(s string) ([]byte, error) {
+	if !strings.HasPrefix(s, "0x") {
+		return nil, fmt.Errorf("httpchain: %q lacks the 0x prefix", s)
+	}
+	return hex.DecodeString(s[2:])
+}
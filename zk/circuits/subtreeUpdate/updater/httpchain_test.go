@@ -0,0 +1,91 @@
+package updater
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"subtreeUpdate/tree"
+	"testing"
+)
+
+// relay serves chain over the protocol of HTTPChain.
+func relay(chain *MemChain) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		root, count, _ := chain.State(r.Context())
+		json.NewEncoder(w).Encode(httpState{Root: root.String(), Count: count})
+	})
+	mux.HandleFunc("/batches/", func(w http.ResponseWriter, r *http.Request) {
+		index, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/batches/"), 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, err := chain.Batch(r.Context(), index)
+		if errors.Is(err, ErrNoBatch) {
+			http.NotFound(w, r)
+			return
+		}
+		hb := httpBatch{Index: b.Index, AccumulatorHash: b.AccumulatorHash.String()}
+		for _, l := range b.Leaves {
+			hb.Leaves = append(hb.Leaves, "0x"+hex.EncodeToString(l[:]))
+		}
+		json.NewEncoder(w).Encode(hb)
+	})
+	mux.HandleFunc("/updates", func(w http.ResponseWriter, r *http.Request) {
+		var hu httpUpdate
+		if err := json.NewDecoder(r.Body).Decode(&hu); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		u := &Update{BatchIndex: hu.BatchIndex, NbBatches: hu.NbBatches}
+		u.OldRoot, _ = new(big.Int).SetString(hu.OldRoot, 10)
+		u.NewRoot, _ = new(big.Int).SetString(hu.NewRoot, 10)
+		proof, err := parseBytes(hu.Proof)
+		if err == nil {
+			u.Proof = proof
+			err = chain.ApplySubtreeUpdate(r.Context(), u)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+	return mux
+}
+
+func TestHTTPChain(t *testing.T) {
+	mem := NewMemChain()
+	insertNotes(mem, 0, 2*tree.BatchSize+3)
+	var proofs []string
+	mem.Verify = func(u *Update, b *Batch) error {
+		proofs = append(proofs, hex.EncodeToString(u.Proof))
+		return nil
+	}
+	server := httptest.NewServer(relay(mem))
+	defer server.Close()
+	chain := &HTTPChain{URL: server.URL + "/"}
+
+	u := newUpdater(t, chain, &fakeProver{}, filepath.Join(t.TempDir(), "checkpoint.json"))
+	drain(t, u)
+	root, count, _ := mem.State(context.Background())
+	if count != 2*tree.BatchSize || u.Root().Cmp(root) != 0 {
+		t.Fatalf("updater at %d/%s, chain at %d/%s", u.Count(), u.Root(), count, root)
+	}
+	// the proofs of fakeProver are the batch indices
+	if len(proofs) != 2 || proofs[1] != "0000000000000001" {
+		t.Fatalf("proofs %v", proofs)
+	}
+
+	// a stale update is refused with the reason of the relay
+	err := chain.ApplySubtreeUpdate(context.Background(), &Update{BatchIndex: 2, OldRoot: big.NewInt(1), NewRoot: big.NewInt(2)})
+	if err == nil || !strings.Contains(err.Error(), "memchain") {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"subtreeUpdate/circuit"
+	"subtreeUpdate/tree"
+	"sync"
+)
+
+// MemChain is an in-memory ChainClient mirroring libs::offchain_merkle_tree, for tests.
+// Updates of several batches mirror apply_subtree_updates.
+// Since it cannot check proofs, it recomputes the expected new root instead and calls
+// Verify, if set, on every submitted update.
+type MemChain struct {
+	mu      sync.Mutex
+	tree    *tree.Tree
+	batches []*Batch
+	batch   [][32]byte
+	// Verify, if set, is called on every update and each of its batches before it is
+	// applied.
+	Verify func(u *Update, b *Batch) error
+}
+
+func NewMemChain() *MemChain {
+	return &MemChain{tree: tree.New()}
+}
+
+// InsertNote mirrors insert_note, taking the already hashed note.
+func (c *MemChain) InsertNote(h [32]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batch = append(c.batch, h)
+	if len(c.batch) == tree.BatchSize {
+		c.batches = append(c.batches, &Batch{
+			Index:           uint64(len(c.batches)),
+			AccumulatorHash: circuit.AccumulatorHash(c.batch),
+			Leaves:          c.batch,
+		})
+		c.batch = nil
+	}
+}
+
+// QueueLength returns the number of accumulated batches waiting for an update.
+func (c *MemChain) QueueLength() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.batches) - int(c.tree.Count()/tree.BatchSize)
+}
+
+func (c *MemChain) State(ctx context.Context) (*big.Int, uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tree.Root(), c.tree.Count(), nil
+}
+
+func (c *MemChain) Batch(ctx context.Context, index uint64) (*Batch, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if index >= uint64(len(c.batches)) {
+		return nil, ErrNoBatch
+	}
+	return c.batches[index], nil
+}
+
+func (c *MemChain) ApplySubtreeUpdate(ctx context.Context, u *Update) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head := c.tree.Count() / tree.BatchSize
+	if head+uint64(u.nbBatches()) > uint64(len(c.batches)) {
+		return fmt.Errorf("memchain: %d batches queued, update of %d", uint64(len(c.batches))-head, u.nbBatches())
+	}
+	if u.BatchIndex != head {
+		return fmt.Errorf("memchain: update for batch %d, queue head is %d", u.BatchIndex, head)
+	}
+	if u.OldRoot.Cmp(c.tree.Root()) != 0 {
+		return errors.New("memchain: stale old root")
+	}
+	next := c.tree.Clone()
+	for _, b := range c.batches[head : head+uint64(u.nbBatches())] {
+		if c.Verify != nil {
+			if err := c.Verify(u, b); err != nil {
+				return err
+			}
+		}
+		for _, l := range b.Leaves {
+			if err := next.Insert(tree.NoteLeaf(l)); err != nil {
+				return err
+			}
+		}
+	}
+	if next.Root().Cmp(u.NewRoot) != 0 {
+		return errors.New("memchain: invalid new root")
+	}
+	c.tree = next
+	return nil
+}
@@ -0,0 +1,141 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// leafSize is the size of a leaf in the log of a FileStore.
+const leafSize = 32
+
+// FileStore keeps the leaves of the checkpoint in an append-only log at Path+".leaves", and
+// their number and the pending update in a JSON file at Path, replaced atomically on every
+// save. A save only appends the leaves inserted since the previous one.
+type FileStore struct {
+	Path string
+	// saved is the number of leaves of the log as of the last Load or Save.
+	saved uint64
+}
+
+// fileCheckpoint is the JSON file of a FileStore.
+type fileCheckpoint struct {
+	// Count is the number of leaves of the checkpoint, the first ones of the log. The log
+	// may hold more, appended by a save that did not complete.
+	Count   uint64
+	Pending *PendingUpdate
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (s *FileStore) logPath() string {
+	return s.Path + ".leaves"
+}
+
+func (s *FileStore) Load() (*Checkpoint, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.saved = 0
+		return &Checkpoint{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fc fileCheckpoint
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return nil, err
+	}
+	cp := &Checkpoint{Leaves: make([][32]byte, fc.Count), Pending: fc.Pending}
+	if fc.Count > 0 {
+		f, err := os.Open(s.logPath())
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		buf := make([]byte, fc.Count*leafSize)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return nil, fmt.Errorf("updater: read %d leaves of %s: %w", fc.Count, s.logPath(), err)
+		}
+		for i := range cp.Leaves {
+			copy(cp.Leaves[i][:], buf[i*leafSize:])
+		}
+	}
+	s.saved = fc.Count
+	return cp, nil
+}
+
+// Save appends the leaves of cp after the saved ones to the log, then replaces the JSON
+// file. The leaves of cp must extend the saved ones.
+func (s *FileStore) Save(cp *Checkpoint) error {
+	count := uint64(len(cp.Leaves))
+	if count < s.saved {
+		return fmt.Errorf("updater: checkpoint of %d leaves, %d saved", count, s.saved)
+	}
+	if count > s.saved {
+		if err := s.appendLeaves(cp.Leaves[s.saved:]); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(&fileCheckpoint{Count: count, Pending: cp.Pending})
+	if err != nil {
+		return err
+	}
+	if err := writeFile(s.Path, b); err != nil {
+		return err
+	}
+	s.saved = count
+	return nil
+}
+
+// appendLeaves writes leaves after the saved ones, over any left by an incomplete save.
+func (s *FileStore) appendLeaves(leaves [][32]byte) error {
+	f, err := os.OpenFile(s.logPath(), os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 0, len(leaves)*leafSize)
+	for _, l := range leaves {
+		buf = append(buf, l[:]...)
+	}
+	offset := int64(s.saved * leafSize)
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteAt(buf, offset); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeFile replaces the file at path with b, through a synced temporary file.
+func writeFile(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
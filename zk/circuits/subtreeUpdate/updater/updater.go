@@ -0,0 +1,339 @@
+// Package updater implements the subtree updater: it watches the accumulator queue of
+// libs::offchain_merkle_tree, applies each queued batch to a local copy of the tree,
+// proves the subtree update and submits it through apply_subtree_update.
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"subtreeUpdate/tree"
+	"time"
+)
+
+// ErrNoBatch is returned by ChainClient.Batch when the requested batch has not been
+// accumulated yet.
+var ErrNoBatch = errors.New("updater: batch not accumulated yet")
+
+// Batch is one entry of the accumulator queue with the note hashes it commits to.
+type Batch struct {
+	// Index is the position of the batch in the queue since genesis; its leaves start at
+	// Index*tree.BatchSize.
+	Index           uint64
+	AccumulatorHash *big.Int
+	Leaves          [][32]byte
+}
+
+// Update is a proven subtree update, i.e. the arguments of apply_subtree_update, or of
+// apply_subtree_updates when it inserts several batches.
+type Update struct {
+	BatchIndex uint64
+	// NbBatches is the number of batches inserted from BatchIndex on, 1 if zero.
+	NbBatches int
+	OldRoot   *big.Int
+	NewRoot   *big.Int
+	Proof     []byte
+}
+
+func (u *Update) nbBatches() int {
+	if u.NbBatches == 0 {
+		return 1
+	}
+	return u.NbBatches
+}
+
+// ChainClient is the view of the on-chain tree the updater needs.
+type ChainClient interface {
+	// State returns the on-chain root and the number of leaves it commits to (count).
+	State(ctx context.Context) (root *big.Int, count uint64, err error)
+	// Batch returns the batch at the given index, whether it is still queued or was
+	// already applied, or ErrNoBatch if it has not been accumulated yet.
+	Batch(ctx context.Context, index uint64) (*Batch, error)
+	// ApplySubtreeUpdate submits an update for the batches at the head of the queue.
+	ApplySubtreeUpdate(ctx context.Context, u *Update) error
+}
+
+// Prover proves the insertion of a batch.
+type Prover interface {
+	// Prove proves inserting b into t, t being the local tree before the insertion.
+	// It must not modify t.
+	Prove(ctx context.Context, t *tree.Tree, b *Batch) ([]byte, error)
+}
+
+// MultiProver is a Prover that also proves the insertion of several consecutive batches at
+// once, with circuit.MultiSubtreeUpdateCircuit. The updater proves as many queued batches
+// as it can in one update. apply_subtree_updates is friend-only until it verifies proofs, so
+// only a chain client calling it through a friend module can submit these updates.
+type MultiProver interface {
+	Prover
+	// NbBatches returns the numbers of batches ProveBatches accepts, one set of keys each.
+	NbBatches() []int
+	// ProveBatches proves inserting batches into t. It must not modify t.
+	ProveBatches(ctx context.Context, t *tree.Tree, batches []*Batch) ([]byte, error)
+}
+
+// Checkpoint is the persisted progress of the updater.
+type Checkpoint struct {
+	// Leaves are all leaves of the local tree, which only holds batches known to be applied on-chain.
+	// They only grow from one checkpoint to the next, so that a Store can append the new
+	// ones instead of writing them all.
+	Leaves [][32]byte
+	// Pending is the update proved for the next batches but not seen on-chain yet.
+	Pending *PendingUpdate
+}
+
+// PendingUpdate is an update together with the leaves of the batches it inserts.
+type PendingUpdate struct {
+	Update
+	Leaves [][32]byte
+}
+
+// Store persists checkpoints. Load returns an empty checkpoint when nothing was saved yet.
+// The leaves of every saved checkpoint extend those of the last one loaded or saved.
+type Store interface {
+	Load() (*Checkpoint, error)
+	Save(*Checkpoint) error
+}
+
+type Updater struct {
+	client ChainClient
+	prover Prover
+	store  Store
+
+	tree    *tree.Tree
+	leaves  [][32]byte
+	pending *PendingUpdate
+
+	// PollInterval is how long Run waits when the queue is empty or a step failed.
+	PollInterval time.Duration
+	Logger       *log.Logger
+}
+
+// New restores the local tree from the store.
+func New(client ChainClient, prover Prover, store Store) (*Updater, error) {
+	cp, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("updater: load checkpoint: %w", err)
+	}
+	u := &Updater{
+		client:       client,
+		prover:       prover,
+		store:        store,
+		tree:         tree.New(),
+		PollInterval: 5 * time.Second,
+		Logger:       log.Default(),
+	}
+	for _, l := range cp.Leaves {
+		if err := u.insert(l); err != nil {
+			return nil, fmt.Errorf("updater: restore tree: %w", err)
+		}
+	}
+	u.pending = cp.Pending
+	return u, nil
+}
+
+// Root returns the root of the local tree.
+func (u *Updater) Root() *big.Int {
+	return u.tree.Root()
+}
+
+// Count returns the number of leaves of the local tree.
+func (u *Updater) Count() uint64 {
+	return u.tree.Count()
+}
+
+// Run calls Step until ctx is done, waiting PollInterval whenever there is nothing to
+// do or a step fails.
+func (u *Updater) Run(ctx context.Context) error {
+	for {
+		progressed, err := u.Step(ctx)
+		if err != nil {
+			u.Logger.Printf("subtree updater: %v", err)
+		}
+		if err != nil || !progressed {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(u.PollInterval):
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Step brings the local tree up to date with the chain and submits at most one update.
+// It reports whether it made progress.
+func (u *Updater) Step(ctx context.Context) (bool, error) {
+	root, count, err := u.client.State(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if u.pending != nil {
+		return u.resolvePending(ctx, root, count)
+	}
+
+	if u.tree.Count() > count {
+		return false, fmt.Errorf("updater: local tree has %d leaves, chain only %d", u.tree.Count(), count)
+	}
+	if u.tree.Count() < count {
+		return true, u.catchUp(ctx, root, count)
+	}
+	if u.tree.Root().Cmp(root) != 0 {
+		return false, fmt.Errorf("updater: local root %s differs from on-chain root %s", u.tree.Root(), root)
+	}
+
+	batches, err := u.queued(ctx, count/tree.BatchSize)
+	if err != nil {
+		return false, err
+	}
+	if len(batches) == 0 {
+		return false, nil
+	}
+
+	var proof []byte
+	if len(batches) == 1 {
+		proof, err = u.prover.Prove(ctx, u.tree, batches[0])
+	} else {
+		proof, err = u.prover.(MultiProver).ProveBatches(ctx, u.tree, batches)
+	}
+	if err != nil {
+		return false, fmt.Errorf("updater: prove %d batches from %d: %w", len(batches), batches[0].Index, err)
+	}
+	next := u.tree.Clone()
+	var leaves [][32]byte
+	for _, b := range batches {
+		for _, l := range b.Leaves {
+			if err := next.Insert(tree.NoteLeaf(l)); err != nil {
+				return false, err
+			}
+		}
+		leaves = append(leaves, b.Leaves...)
+	}
+	u.pending = &PendingUpdate{
+		Update: Update{
+			BatchIndex: batches[0].Index,
+			NbBatches:  len(batches),
+			OldRoot:    u.tree.Root(),
+			NewRoot:    next.Root(),
+			Proof:      proof,
+		},
+		Leaves: leaves,
+	}
+	// persist before submitting so that a restart never proves the same batch twice
+	if err := u.save(); err != nil {
+		return false, err
+	}
+	if err := u.client.ApplySubtreeUpdate(ctx, &u.pending.Update); err != nil {
+		return true, fmt.Errorf("updater: submit batch %d: %w", u.pending.BatchIndex, err)
+	}
+	return true, u.commitPending()
+}
+
+// queued returns the batches of the next update, from the batch at index on: as many
+// queued batches as the prover proves at once, the largest of MultiProver.NbBatches not
+// above the queue length, or a single one.
+func (u *Updater) queued(ctx context.Context, index uint64) ([]*Batch, error) {
+	sizes := []int{1}
+	if p, ok := u.prover.(MultiProver); ok {
+		sizes = append(sizes, p.NbBatches()...)
+	}
+	most := 0
+	for _, n := range sizes {
+		if n > most {
+			most = n
+		}
+	}
+	var batches []*Batch
+	for len(batches) < most {
+		b, err := u.client.Batch(ctx, index+uint64(len(batches)))
+		if errors.Is(err, ErrNoBatch) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(b.Leaves) != tree.BatchSize {
+			return nil, tree.ErrBatchSize
+		}
+		batches = append(batches, b)
+	}
+	n := 0
+	for _, size := range sizes {
+		if size <= len(batches) && size > n {
+			n = size
+		}
+	}
+	return batches[:n], nil
+}
+
+// resolvePending finishes an update left over by a failed submission or a restart.
+func (u *Updater) resolvePending(ctx context.Context, root *big.Int, count uint64) (bool, error) {
+	p := u.pending
+	switch {
+	case count == u.tree.Count()+uint64(len(p.Leaves)):
+		// the update landed, or someone else applied the same batches
+		return true, u.commitPending()
+	case count > u.tree.Count():
+		// someone else applied another number of batches, catch up instead
+		u.pending = nil
+		return true, u.save()
+	case root.Cmp(p.OldRoot) == 0:
+		if err := u.client.ApplySubtreeUpdate(ctx, &p.Update); err != nil {
+			return false, fmt.Errorf("updater: resubmit batch %d: %w", p.BatchIndex, err)
+		}
+		return true, u.commitPending()
+	default:
+		return false, fmt.Errorf("updater: on-chain root %s matches neither side of pending update %d", root, p.BatchIndex)
+	}
+}
+
+func (u *Updater) commitPending() error {
+	for _, l := range u.pending.Leaves {
+		if err := u.insert(l); err != nil {
+			return err
+		}
+	}
+	if u.tree.Root().Cmp(u.pending.NewRoot) != 0 {
+		return fmt.Errorf("updater: batch %d produced root %s, expected %s", u.pending.BatchIndex, u.tree.Root(), u.pending.NewRoot)
+	}
+	u.pending = nil
+	return u.save()
+}
+
+// catchUp inserts batches applied on-chain by someone else.
+func (u *Updater) catchUp(ctx context.Context, root *big.Int, count uint64) error {
+	for u.tree.Count() < count {
+		b, err := u.client.Batch(ctx, u.tree.Count()/tree.BatchSize)
+		if err != nil {
+			return fmt.Errorf("updater: fetch applied batch: %w", err)
+		}
+		for _, l := range b.Leaves {
+			if err := u.insert(l); err != nil {
+				return err
+			}
+		}
+	}
+	if u.tree.Root().Cmp(root) != 0 {
+		return fmt.Errorf("updater: local root %s differs from on-chain root %s after catching up", u.tree.Root(), root)
+	}
+	return u.save()
+}
+
+func (u *Updater) insert(l [32]byte) error {
+	if err := u.tree.Insert(tree.NoteLeaf(l)); err != nil {
+		return err
+	}
+	u.leaves = append(u.leaves, l)
+	return nil
+}
+
+func (u *Updater) save() error {
+	if err := u.store.Save(&Checkpoint{Leaves: u.leaves, Pending: u.pending}); err != nil {
+		return fmt.Errorf("updater: save checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"subtreeUpdate/tree"
+	"testing"
+	"time"
+)
+
+// fakeProver returns the batch index as proof and counts its calls.
+type fakeProver struct {
+	calls int
+}
+
+func (p *fakeProver) Prove(ctx context.Context, t *tree.Tree, b *Batch) ([]byte, error) {
+	p.calls++
+	if t.Count() != b.Index*tree.BatchSize {
+		return nil, errors.New("tree and batch out of sync")
+	}
+	return binary.BigEndian.AppendUint64(nil, b.Index), nil
+}
+
+// flakyChain fails the next submission, either before or after applying it.
+type flakyChain struct {
+	*MemChain
+	failBefore, failAfter bool
+}
+
+func (c *flakyChain) ApplySubtreeUpdate(ctx context.Context, u *Update) error {
+	if c.failBefore {
+		c.failBefore = false
+		return errors.New("connection reset")
+	}
+	if err := c.MemChain.ApplySubtreeUpdate(ctx, u); err != nil {
+		return err
+	}
+	if c.failAfter {
+		c.failAfter = false
+		return errors.New("timeout waiting for effects")
+	}
+	return nil
+}
+
+func insertNotes(c *MemChain, from, n int) {
+	for i := from; i < from+n; i++ {
+		c.InsertNote(sha256.Sum256(binary.BigEndian.AppendUint64(nil, uint64(i))))
+	}
+}
+
+func newUpdater(t *testing.T, c ChainClient, p Prover, path string) *Updater {
+	u, err := New(c, p, NewFileStore(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func drain(t *testing.T, u *Updater) {
+	for i := 0; i < 100; i++ {
+		progressed, err := u.Step(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !progressed {
+			return
+		}
+	}
+	t.Fatal("updater did not settle")
+}
+
+func TestDrainsQueue(t *testing.T) {
+	chain := NewMemChain()
+	insertNotes(chain, 0, 3*tree.BatchSize+5)
+	p := &fakeProver{}
+	u := newUpdater(t, chain, p, filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	drain(t, u)
+	if chain.QueueLength() != 0 {
+		t.Fatalf("queue length %d, want 0", chain.QueueLength())
+	}
+	if p.calls != 3 {
+		t.Fatalf("proved %d batches, want 3", p.calls)
+	}
+	root, count, _ := chain.State(context.Background())
+	if count != 3*tree.BatchSize || u.Root().Cmp(root) != 0 {
+		t.Fatalf("updater at %d/%s, chain at %d/%s", u.Count(), u.Root(), count, root)
+	}
+
+	// the remaining notes complete a fourth batch
+	insertNotes(chain, 3*tree.BatchSize+5, tree.BatchSize-5)
+	drain(t, u)
+	if chain.QueueLength() != 0 || p.calls != 4 {
+		t.Fatalf("queue length %d, %d proofs", chain.QueueLength(), p.calls)
+	}
+}
+
+func TestRestartKeepsProgress(t *testing.T) {
+	chain := NewMemChain()
+	insertNotes(chain, 0, 2*tree.BatchSize)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	drain(t, newUpdater(t, chain, &fakeProver{}, path))
+
+	insertNotes(chain, 2*tree.BatchSize, tree.BatchSize)
+	p := &fakeProver{}
+	u := newUpdater(t, chain, p, path)
+	if u.Count() != 2*tree.BatchSize {
+		t.Fatalf("restored %d leaves, want %d", u.Count(), 2*tree.BatchSize)
+	}
+	drain(t, u)
+	if p.calls != 1 || chain.QueueLength() != 0 {
+		t.Fatalf("%d proofs, queue length %d", p.calls, chain.QueueLength())
+	}
+}
+
+func TestRestartAfterFailedSubmission(t *testing.T) {
+	chain := &flakyChain{MemChain: NewMemChain(), failBefore: true}
+	insertNotes(chain.MemChain, 0, tree.BatchSize)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	p := &fakeProver{}
+	if _, err := newUpdater(t, chain, p, path).Step(context.Background()); err == nil {
+		t.Fatal("expected submission to fail")
+	}
+
+	// the proof is persisted and resubmitted instead of being proved again
+	u := newUpdater(t, chain, p, path)
+	drain(t, u)
+	if p.calls != 1 {
+		t.Fatalf("proved %d times, want 1", p.calls)
+	}
+	if chain.QueueLength() != 0 || u.Count() != tree.BatchSize {
+		t.Fatalf("queue length %d, local count %d", chain.QueueLength(), u.Count())
+	}
+}
+
+func TestRestartAfterUnconfirmedSubmission(t *testing.T) {
+	chain := &flakyChain{MemChain: NewMemChain(), failAfter: true}
+	insertNotes(chain.MemChain, 0, 2*tree.BatchSize)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	p := &fakeProver{}
+	if _, err := newUpdater(t, chain, p, path).Step(context.Background()); err == nil {
+		t.Fatal("expected submission to fail")
+	}
+
+	// the update landed although the client reported an error
+	u := newUpdater(t, chain, p, path)
+	drain(t, u)
+	if p.calls != 2 {
+		t.Fatalf("proved %d times, want 2", p.calls)
+	}
+	root, count, _ := chain.State(context.Background())
+	if count != 2*tree.BatchSize || u.Root().Cmp(root) != 0 {
+		t.Fatalf("updater at %d/%s, chain at %d/%s", u.Count(), u.Root(), count, root)
+	}
+}
+
+func TestCatchesUpWithOtherUpdaters(t *testing.T) {
+	chain := NewMemChain()
+	insertNotes(chain, 0, 2*tree.BatchSize)
+	drain(t, newUpdater(t, chain, &fakeProver{}, filepath.Join(t.TempDir(), "other.json")))
+
+	insertNotes(chain, 2*tree.BatchSize, tree.BatchSize)
+	p := &fakeProver{}
+	u := newUpdater(t, chain, p, filepath.Join(t.TempDir(), "checkpoint.json"))
+	drain(t, u)
+	if p.calls != 1 || u.Count() != 3*tree.BatchSize {
+		t.Fatalf("%d proofs, local count %d", p.calls, u.Count())
+	}
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	chain := NewMemChain()
+	insertNotes(chain, 0, tree.BatchSize)
+	u := newUpdater(t, chain, &fakeProver{}, filepath.Join(t.TempDir(), "checkpoint.json"))
+	u.PollInterval = time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := u.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+	if chain.QueueLength() != 0 {
+		t.Fatalf("queue length %d, want 0", chain.QueueLength())
+	}
+}
+
+// fakeMultiProver also proves 2 or 4 batches at once and records the size of each update.
+type fakeMultiProver struct {
+	fakeProver
+	updates []int
+}
+
+func (p *fakeMultiProver) Prove(ctx context.Context, t *tree.Tree, b *Batch) ([]byte, error) {
+	p.updates = append(p.updates, 1)
+	return p.fakeProver.Prove(ctx, t, b)
+}
+
+func (p *fakeMultiProver) NbBatches() []int {
+	return []int{4, 2}
+}
+
+func (p *fakeMultiProver) ProveBatches(ctx context.Context, t *tree.Tree, batches []*Batch) ([]byte, error) {
+	p.updates = append(p.updates, len(batches))
+	for i, b := range batches {
+		if b.Index != batches[0].Index+uint64(i) {
+			return nil, errors.New("batches are not consecutive")
+		}
+	}
+	return p.fakeProver.Prove(ctx, t, batches[0])
+}
+
+func TestProvesSeveralBatches(t *testing.T) {
+	chain := NewMemChain()
+	insertNotes(chain, 0, 7*tree.BatchSize)
+	p := &fakeMultiProver{}
+	u := newUpdater(t, chain, p, filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	drain(t, u)
+	if len(p.updates) != 3 || p.updates[0] != 4 || p.updates[1] != 2 || p.updates[2] != 1 {
+		t.Fatalf("updates of %v batches, want [4 2 1]", p.updates)
+	}
+	root, count, _ := chain.State(context.Background())
+	if chain.QueueLength() != 0 || count != 7*tree.BatchSize || u.Root().Cmp(root) != 0 {
+		t.Fatalf("updater at %d/%s, chain at %d/%s", u.Count(), u.Root(), count, root)
+	}
+}
+
+func TestPendingOverlapsOtherUpdate(t *testing.T) {
+	chain := &flakyChain{MemChain: NewMemChain(), failBefore: true}
+	insertNotes(chain.MemChain, 0, 2*tree.BatchSize)
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	if _, err := newUpdater(t, chain, &fakeMultiProver{}, path).Step(context.Background()); err == nil {
+		t.Fatal("expected submission to fail")
+	}
+
+	// another updater applies the first batch only, the pending update of both is dropped
+	other := newUpdater(t, chain.MemChain, &fakeProver{}, filepath.Join(t.TempDir(), "other.json"))
+	if _, err := other.Step(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	insertNotes(chain.MemChain, 2*tree.BatchSize, tree.BatchSize)
+	p := &fakeMultiProver{}
+	u := newUpdater(t, chain, p, path)
+	drain(t, u)
+	if len(p.updates) != 1 || p.updates[0] != 2 {
+		t.Fatalf("updates of %v batches, want [2]", p.updates)
+	}
+	root, count, _ := chain.State(context.Background())
+	if count != 3*tree.BatchSize || u.Root().Cmp(root) != 0 {
+		t.Fatalf("updater at %d/%s, chain at %d/%s", u.Count(), u.Root(), count, root)
+	}
+}
+
+func TestFileStoreAppendsLeaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	leaves := make([][32]byte, 3*tree.BatchSize)
+	for i := range leaves {
+		leaves[i] = sha256.Sum256(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	}
+	s := NewFileStore(path)
+	if err := s.Save(&Checkpoint{Leaves: leaves[:tree.BatchSize]}); err != nil {
+		t.Fatal(err)
+	}
+	// a save that appended its leaves but did not replace the JSON file
+	f, err := os.OpenFile(path+".leaves", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(make([]byte, 40)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s = NewFileStore(path)
+	cp, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Leaves) != tree.BatchSize || cp.Leaves[tree.BatchSize-1] != leaves[tree.BatchSize-1] {
+		t.Fatalf("loaded %d leaves", len(cp.Leaves))
+	}
+	if err := s.Save(&Checkpoint{Leaves: leaves}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path + ".leaves")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(32*len(leaves)) {
+		t.Fatalf("leaf log of %d bytes, want %d", info.Size(), 32*len(leaves))
+	}
+	if err := s.Save(&Checkpoint{Leaves: leaves[:tree.BatchSize]}); err == nil {
+		t.Fatal("checkpoint dropping leaves was saved")
+	}
+
+	cp, err = NewFileStore(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cp.Leaves) != len(leaves) || cp.Leaves[len(leaves)-1] != leaves[len(leaves)-1] {
+		t.Fatalf("loaded %d leaves, want %d", len(cp.Leaves), len(leaves))
+	}
+}